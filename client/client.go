@@ -0,0 +1,263 @@
+// Package client is a Go client for the azure-speech-cache proxy.
+//
+//	c := client.New("http://localhost:8080", client.WithAPIKey("..."))
+//	audio, err := c.Synthesize(ctx, client.Request{Text: "Hello world!", Language: "en-US", Name: "en-US-BrianNeural"})
+//	if err != nil {
+//		return err
+//	}
+//	defer audio.Close()
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRetryDelay = 10 * time.Second
+
+// Request has the same fields as the /tts request body.
+type Request struct {
+	Text            string            `json:"text,omitempty"`
+	SSML            string            `json:"ssml,omitempty"`
+	Language        string            `json:"language,omitempty"`
+	Gender          string            `json:"gender,omitempty"`
+	Name            string            `json:"name,omitempty"`
+	Style           string            `json:"style,omitempty"`
+	StyleDegree     string            `json:"styleDegree,omitempty"`
+	Role            string            `json:"role,omitempty"`
+	Rate            string            `json:"rate,omitempty"`
+	Pitch           string            `json:"pitch,omitempty"`
+	Volume          string            `json:"volume,omitempty"`
+	AzureKey        string            `json:"azureKey,omitempty"`
+	AzureRegion     string            `json:"azureRegion,omitempty"`
+	ShouldCache     bool              `json:"shouldCache"`
+	AllowMarkup     bool              `json:"allowMarkup,omitempty"`
+	Split           bool              `json:"split,omitempty"`
+	DeploymentID    string            `json:"deploymentId,omitempty"`
+	LexiconURL      string            `json:"lexiconUrl,omitempty"`
+	Phonemes        map[string]string `json:"phonemes,omitempty"`
+	PhonemeAlphabet string            `json:"phonemeAlphabet,omitempty"`
+	Segments        []Segment         `json:"segments,omitempty"`
+	LeadingPause    int               `json:"leadingPauseMs,omitempty"`
+	TrailingPause   int               `json:"trailingPauseMs,omitempty"`
+	Provider        string            `json:"provider,omitempty"`
+	ForceRefresh    bool              `json:"forceRefresh,omitempty"`
+	// TTLSeconds is how long the audio is cached, 0 means forever
+	TTLSeconds *int `json:"ttlSeconds,omitempty"`
+	// Namespace partitions the cache, inside the namespace of the api key
+	// when the proxy has tenant namespaces
+	Namespace string `json:"namespace,omitempty"`
+	// Format is the encoding the audio is transcoded to, e.g. opus
+	Format string `json:"format,omitempty"`
+}
+
+// Segment is a part of a dialogue spoken by its own voice.
+type Segment struct {
+	Text     string `json:"text"`
+	Name     string `json:"name,omitempty"`
+	Style    string `json:"style,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+// Client calls the /tts endpoint of the proxy.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	retries    int
+	retryDelay time.Duration
+}
+
+type Option func(*Client)
+
+// WithAPIKey sets the api key sent in the Authorization header.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient sets the http client, the default one has no timeout
+// so long audio can be streamed.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetries sets how many times failed requests (network errors, 429 and
+// 5xx) are retried, default is 2. The delay is doubled on every attempt and
+// the proxy's Retry-After header takes precedence.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryDelay = delay
+	}
+}
+
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retries:    2,
+		retryDelay: 500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Synthesize returns the audio of the request. The audio is streamed, the
+// caller has to close it. Failed responses are returned as *Error.
+func (c *Client) Synthesize(ctx context.Context, r Request) (io.ReadCloser, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.backoff(attempt, lastErr)):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/tts", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if c.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp.Body, nil
+		}
+
+		apiErr := responseError(resp)
+		lastErr = apiErr
+		// don't wait for the monthly quota to reset
+		if !isRetryable(resp.StatusCode) || apiErr.RetryAfter > maxRetryDelay {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (c *Client) backoff(attempt int, lastErr error) time.Duration {
+	var apiErr *Error
+	if errors.As(lastErr, &apiErr) && apiErr.RetryAfter > 0 {
+		return min(apiErr.RetryAfter, maxRetryDelay)
+	}
+
+	delay := min(c.retryDelay<<(attempt-1), maxRetryDelay)
+	// full jitter between half and the whole delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func isRetryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Error is a failed response of the proxy.
+type Error struct {
+	StatusCode int
+	// Code is a machine readable reason, like invalid_voice or rate_limited
+	Code string
+	// Field is the request field the error is about, if any
+	Field      string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("speech cache returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("speech cache returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is match the error against ErrBadRequest, ErrUnauthorized,
+// ErrRateLimited and ErrUnavailable.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRateLimited is returned for the rate limits and the monthly quota
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnavailable is returned while the circuit breaker is open
+	ErrUnavailable = errors.New("unavailable")
+)
+
+func responseError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var response struct {
+		Error struct {
+			Code    string `json:"code"`
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Error.Code != "" {
+		apiErr.Code = response.Error.Code
+		apiErr.Field = response.Error.Field
+		apiErr.Message = response.Error.Message
+	}
+	return apiErr
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/nerijusdu/azure-speech-cache/server"
+)
+
+// The maintenance commands open the cache directly. With the file and disk
+// backends they should be run while the server is stopped, otherwise the
+// server overwrites their changes on its next save.
+
+func warm(args []string) error {
+	flags := flag.NewFlagSet("warm", flag.ExitOnError)
+	language := flags.String("language", "", "language of the phrases in a text file")
+	gender := flags.String("gender", "", "voice gender of the phrases in a text file")
+	name := flags.String("name", "", "voice name of the phrases in a text file")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: azure-speech-cache warm [flags] <file>\n\nThe file is a json array of /tts request bodies, or text with a phrase per line. Use - to read stdin.")
+		flags.PrintDefaults()
+	}
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	requests, err := readPhrases(flags.Arg(0), server.Request{Language: *language, Gender: *gender, Name: *name})
+	if err != nil {
+		return err
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result := srv.Warm(ctx, requests)
+	if err := printJSON(result); err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", result.Failed, result.Total)
+	}
+	return nil
+}
+
+// readPhrases reads the warm requests from a json file or a text file
+// with a phrase per line, the text phrases use the voice of template.
+func readPhrases(path string, template server.Request) ([]server.Request, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		var requests []server.Request
+		if err := json.Unmarshal(data, &requests); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return requests, nil
+	}
+
+	var requests []server.Request
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		phrase := strings.TrimSpace(scanner.Text())
+		if phrase == "" || strings.HasPrefix(phrase, "#") {
+			continue
+		}
+		request := template
+		request.Text = phrase
+		requests = append(requests, request)
+	}
+	return requests, scanner.Err()
+}
+
+func export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "-", "file to write the archive to, - for stdout")
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return srv.Export(os.Stdout)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := srv.Export(file); err != nil {
+		file.Close()
+		os.Remove(*output)
+		return err
+	}
+	return file.Close()
+}
+
+func exportDir(args []string) error {
+	flags := flag.NewFlagSet("export-dir", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: azure-speech-cache export-dir [flags] <dir>\n\nWrites the audio as {hash}.mp3 files and a manifest.json that maps the cache keys and texts to them.")
+		flags.PrintDefaults()
+	}
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	exported, err := srv.ExportDir(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"exported": exported,
+	})
+}
+
+func importDir(args []string) error {
+	flags := flag.NewFlagSet("import-dir", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: azure-speech-cache import-dir [flags] <dir>\n\nThe directory has the audio files and a manifest.json with a /tts request body per file, plus \"file\" and optionally \"contentType\".")
+		flags.PrintDefaults()
+	}
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	result, err := srv.ImportDir(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := printJSON(result); err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", result.Failed, result.Total)
+	}
+	return nil
+}
+
+func backup(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("backup", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	name, err := srv.Backup(context.Background())
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"name": name,
+	})
+}
+
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: azure-speech-cache restore [flags] [name]\n\nRestores the backup of backup_url with the name, or the newest one.")
+		flags.PrintDefaults()
+	}
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	name, restored, err := srv.Restore(context.Background(), flags.Arg(0))
+	if err != nil && restored > 0 {
+		return fmt.Errorf("restore of %s failed after %d entries: %w", name, restored, err)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"name":     name,
+		"restored": restored,
+	})
+}
+
+func purge(args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	var filter server.PurgeFilter
+	flags.StringVar(&filter.Voice, "voice", "", "only delete entries of this voice")
+	flags.StringVar(&filter.Language, "language", "", "only delete entries of this language")
+	flags.StringVar(&filter.Namespace, "namespace", "", "only delete entries of this namespace")
+	flags.DurationVar(&filter.OlderThan, "older-than", 0, "only delete entries older than this, e.g. 720h")
+	all := flags.Bool("all", false, "delete all entries when no filter is set")
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	if filter == (server.PurgeFilter{}) && !*all {
+		return fmt.Errorf("set -voice, -language, -namespace or -older-than, or -all to delete everything")
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	return printJSON(map[string]interface{}{
+		"deleted": srv.Purge(filter),
+	})
+}
+
+func stats(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("stats", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+	return printJSON(srv.Stats())
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
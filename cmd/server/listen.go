@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/nerijusdu/azure-speech-cache/server"
+)
+
+// listenHTTP opens the listeners of the http api: the sockets passed by
+// systemd socket activation or the tcp port, and the unix socket. TLS is
+// used on all of them except the unix socket.
+func listenHTTP(cfg server.Config, tlsConfig *tls.Config) ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, fmt.Errorf("failed to use systemd sockets: %w", err)
+	}
+	if len(listeners) == 0 && cfg.Port != "" {
+		listener, err := net.Listen("tcp", ":"+cfg.Port)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	if tlsConfig != nil {
+		for i, listener := range listeners {
+			listeners[i] = tls.NewListener(listener, tlsConfig)
+		}
+	}
+
+	if cfg.SocketPath != "" {
+		// a socket left behind by a crash would fail the listen
+		if err := os.Remove(cfg.SocketPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", cfg.SocketPath)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("nothing to listen on, set port or socket_path")
+	}
+	return listeners, nil
+}
+
+// systemdListeners returns the sockets passed with systemd socket activation,
+// see sd_listen_fds(3).
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+	// don't pass the sockets on to child processes like LOCAL_TTS_COMMAND
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const firstFD = 3
+	var listeners []net.Listener
+	for fd := firstFD; fd < firstFD+count; fd++ {
+		file := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
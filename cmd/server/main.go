@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/server"
+)
+
+var commands = map[string]func(args []string) error{
+	"serve":      serve,
+	"warm":       warm,
+	"export":     export,
+	"export-dir": exportDir,
+	"import-dir": importDir,
+	"backup":     backup,
+	"restore":    restore,
+	"purge":      purge,
+	"stats":      stats,
+}
+
+func main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	// only serve logs to stdout, the other commands write their output there
+	logOutput := os.Stderr
+	if command == "serve" {
+		logOutput = os.Stdout
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logOutput, nil)))
+
+	if err := run(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: azure-speech-cache [command] [flags]
+
+Commands:
+  serve       run the http and grpc api (default)
+  warm        synthesize the phrases of a file into the cache
+  export      write the cache as a .tar.gz archive
+  export-dir  write the cache as audio files with a manifest
+  import-dir  add a directory of audio files to the cache
+  backup      upload the cache to the backup_url
+  restore     import a backup from the backup_url
+  purge       delete cache entries
+  stats       print the cache statistics
+
+Run azure-speech-cache [command] -h for the flags of a command.
+`)
+}
+
+// loadConfig adds the -config flag, parses the flags and loads the config.
+func loadConfig(flags *flag.FlagSet, args []string) (server.Config, error) {
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path of the yaml config file")
+	flags.Parse(args)
+	return server.LoadConfig(*configFile)
+}
+
+func serve(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("serve", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := setupTLS(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up tls: %w", err)
+	}
+
+	listeners, err := listenHTTP(cfg, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	// net/http/pprof registers on the default mux
+	mux.Handle("/debug/pprof/", srv.RequireAdmin(http.DefaultServeMux))
+	mux.Handle("/", srv)
+	httpServer := &http.Server{Handler: mux}
+	for _, listener := range listeners {
+		go func() {
+			slog.Info("Listening", "address", listener.Addr().String(), "tls", tlsConfig != nil && listener.Addr().Network() != "unix")
+			if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	grpcServer := srv.GRPC()
+	if cfg.GRPCPort != "" {
+		listener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			return fmt.Errorf("failed to listen for grpc: %w", err)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		go func() {
+			slog.Info("Listening for grpc", "port", cfg.GRPCPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go srv.Run(ctx)
+	<-ctx.Done()
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to drain requests", "error", err)
+	}
+	grpcServer.GracefulStop()
+
+	srv.Close()
+	shutdownTracing(shutdownCtx)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"path/filepath"
+	"strings"
+
+	"github.com/nerijusdu/azure-speech-cache/server"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// setupTLS returns the tls config of the listeners, or nil when tls is not configured.
+// Autocert answers the TLS-ALPN-01 challenge itself, so the port has to be
+// reachable as 443 from the internet.
+func setupTLS(cfg server.Config) (*tls.Config, error) {
+	if cfg.TLSAutocertDomains != "" {
+		var domains []string
+		for _, domain := range strings.Split(cfg.TLSAutocertDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(filepath.Join(cmp.Or(cfg.CacheDir, "."), "autocert")),
+			Email:      cfg.TLSAutocertEmail,
+		}
+		return manager.TLSConfig(), nil
+	}
+
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// entryAccess is how often a cache entry was served and when it was served last
+type entryAccess struct {
+	Hits         int64     `json:"hits"`
+	LastAccessed time.Time `json:"lastAccessed"`
+}
+
+// hitCounter records the accesses per key, they're saved to access.json
+// in CACHE_DIR so they survive restarts.
+type hitCounter struct {
+	mu     sync.Mutex
+	counts map[string]entryAccess
+	dirty  atomic.Bool
+}
+
+var hits = &hitCounter{counts: make(map[string]entryAccess)}
+
+func (h *hitCounter) add(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	access := h.counts[key]
+	access.Hits++
+	access.LastAccessed = time.Now()
+	h.counts[key] = access
+	h.dirty.Store(true)
+}
+
+func (h *hitCounter) get(key string) entryAccess {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.counts[key]
+}
+
+func (h *hitCounter) all() map[string]entryAccess {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return maps.Clone(h.counts)
+}
+
+func (h *hitCounter) remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.counts[key]; ok {
+		delete(h.counts, key)
+		h.dirty.Store(true)
+	}
+}
+
+// keep drops the accesses of keys that are not in the cache anymore.
+func (h *hitCounter) keep(keys map[string]cache.Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.counts {
+		if _, ok := keys[key]; !ok {
+			delete(h.counts, key)
+			h.dirty.Store(true)
+		}
+	}
+}
+
+func accessFile() string {
+	return filepath.Join(cacheDir, "access.json")
+}
+
+func (h *hitCounter) load() {
+	data, err := os.ReadFile(accessFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Failed to read access file", "error", err)
+		}
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := json.Unmarshal(data, &h.counts); err != nil {
+		slog.Error("Failed to decode access file", "error", err)
+		h.counts = make(map[string]entryAccess)
+	}
+}
+
+func (h *hitCounter) save() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dirty.Store(false)
+
+	if err := cache.WriteFileAtomic(accessFile(), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(h.counts)
+	}); err != nil {
+		slog.Error("Failed to save access file", "error", err)
+		h.dirty.Store(true)
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+type cacheListItem struct {
+	Key          string     `json:"key"`
+	Text         string     `json:"text"`
+	Voice        string     `json:"voice"`
+	Namespace    string     `json:"namespace,omitempty"`
+	Size         int64      `json:"size"`
+	ContentType  string     `json:"contentType"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	Hits         int64      `json:"hits"`
+	LastAccessed *time.Time `json:"lastAccessed,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	Permanent    bool       `json:"permanent"`
+}
+
+// adminKey protects the cache admin endpoints, they are disabled when it's not set
+var adminKey string
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminKey == "" {
+			httpError(w, "admin api is disabled, set ADMIN_KEY to enable it", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(requestAPIKey(r)), []byte(adminKey)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, "invalid or missing admin key", http.StatusUnauthorized)
+			return
+		}
+		requestInfoFrom(r.Context()).Client = "admin"
+		next(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, "admin")))
+	}
+}
+
+func deleteEntry(key string) bool {
+	_, permanent := c.Get(key)
+	_, temp := tempC.Get(key)
+	if !permanent && !temp {
+		return false
+	}
+
+	c.Delete(key)
+	tempC.Delete(key)
+	hits.remove(key)
+	if permanent {
+		cacheDeleted(key)
+	}
+	return true
+}
+
+func handleDeleteCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if !deleteEntry(r.PathValue("key")) {
+		httpError(w, "cache entry not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteCacheQuery deletes the entry matching the same query
+// parameters as GET /tts. Entries of tenants are deleted with their full
+// namespace, e.g. namespace=app1 or namespace=app1/news.
+func handleDeleteCacheQuery(w http.ResponseWriter, r *http.Request) {
+	ttsRequest := ttsRequestFromQuery(r.URL.Query())
+	// the defaults are part of the key, like for /tts, the credentials
+	// aren't so they aren't needed
+	if err := applyDefaults(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !deleteEntry(cacheKey(ttsRequest)) {
+		httpError(w, "cache entry not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListCache lists cache entries with offset and limit pagination, newest
+// first or sorted by sort=hits or sort=lastAccessed, optionally only the
+// ones of a namespace.
+func handleListCache(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	limit = min(limit, 1000)
+	offset = max(offset, 0)
+	namespace, filterNamespace := query.Get("namespace"), query.Has("namespace")
+
+	items := []cacheListItem{}
+	for _, store := range []struct {
+		store     cache.Store
+		permanent bool
+	}{{c, true}, {tempC, false}} {
+		for key, entry := range store.store.Items() {
+			if filterNamespace && entry.Namespace != namespace {
+				continue
+			}
+			access := hits.get(key)
+			item := cacheListItem{
+				Key:         key,
+				Text:        snippet(entry.Text, 80),
+				Voice:       entry.Voice,
+				Namespace:   entry.Namespace,
+				Size:        cache.Size(key, entry) - int64(len(key)),
+				ContentType: entry.Type,
+				CreatedAt:   entry.Created,
+				Hits:        access.Hits,
+				Permanent:   store.permanent,
+			}
+			if !access.LastAccessed.IsZero() {
+				item.LastAccessed = &access.LastAccessed
+			}
+			if !entry.Expires.IsZero() {
+				item.ExpiresAt = &entry.Expires
+			}
+			items = append(items, item)
+		}
+	}
+
+	sortBy := query.Get("sort")
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		switch {
+		case sortBy == "hits" && a.Hits != b.Hits:
+			return a.Hits > b.Hits
+		case sortBy == "lastAccessed" && lastAccessed(a) != lastAccessed(b):
+			return lastAccessed(a).After(lastAccessed(b))
+		case !a.CreatedAt.Equal(b.CreatedAt):
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.Key < b.Key
+	})
+
+	total := len(items)
+	page := items[min(offset, total):min(offset+limit, total)]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+		"items":  page,
+	})
+}
+
+func lastAccessed(item cacheListItem) time.Time {
+	if item.LastAccessed == nil {
+		return time.Time{}
+	}
+	return *item.LastAccessed
+}
+
+func snippet(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "…"
+}
+
+type flushRequest struct {
+	Voice     string `json:"voice"`
+	Language  string `json:"language"`
+	Namespace string `json:"namespace"`
+	OlderThan string `json:"olderThan"`
+}
+
+// handleFlushCache deletes all entries, or the ones matching the filters,
+// and saves the cache file right away.
+func handleFlushCache(w http.ResponseWriter, r *http.Request) {
+	var filter flushRequest
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &filter); err != nil && err != io.EOF {
+			bodyError(w, err)
+			return
+		}
+	}
+
+	var olderThan time.Duration
+	if filter.OlderThan != "" {
+		var err error
+		olderThan, err = time.ParseDuration(filter.OlderThan)
+		if err != nil {
+			httpError(w, "invalid olderThan: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deleted := purge(PurgeFilter{Voice: filter.Voice, Language: filter.Language, Namespace: filter.Namespace, OlderThan: olderThan})
+	if persist {
+		saveCache()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+	})
+}
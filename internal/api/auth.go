@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type clientKey struct{}
+
+// apiKeys maps proxy api keys to client names used in logs
+var apiKeys map[string]string
+
+func parseAPIKeys(value string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(item, ":")
+		if !ok {
+			key = name
+			name = maskKey(key)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty key for %q", name)
+		}
+		keys[key] = name
+	}
+	return keys, nil
+}
+
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
+
+// requireAPIKey rejects requests without a valid api key when API_KEYS is set.
+// Signed GET urls are authorized by their signature instead.
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) == 0 {
+			next(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet && signingSecret != "" && r.URL.Query().Has("signature") {
+			// the signature has to be checked here, not every handler checks it
+			if err := verifySignature(signedQuery(r)); err != nil {
+				writeError(w, err, http.StatusUnauthorized)
+				return
+			}
+			// urls signed for a client, e.g. by /tts.twiml, count against its
+			// limits and use its credentials
+			name := "signed-url"
+			ctx := r.Context()
+			if client := r.URL.Query().Get("client"); client != "" {
+				name = client
+				ctx = withTenant(ctx, name)
+				requestInfoFrom(ctx).Client = name
+			}
+			next(w, r.WithContext(context.WithValue(ctx, clientKey{}, name)))
+			return
+		}
+
+		name, ok := lookupAPIKey(requestAPIKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, "invalid or missing api key", http.StatusUnauthorized)
+			return
+		}
+
+		requestInfoFrom(r.Context()).Client = name
+		ctx := context.WithValue(r.Context(), clientKey{}, name)
+		next(w, r.WithContext(withTenant(ctx, name)))
+	}
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+func lookupAPIKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for candidate, name := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// clientName returns the name of the authenticated client, if any.
+func clientName(ctx context.Context) string {
+	name, _ := ctx.Value(clientKey{}).(string)
+	return name
+}
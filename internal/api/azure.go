@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+var azureRetries = 2
+var azureRetryDelay = 500 * time.Millisecond
+
+const maxRetryDelay = 10 * time.Second
+
+type azureTarget struct {
+	Region string
+	Key    string
+}
+
+// failoverTargets are tried in order when the primary region is down
+var failoverTargets []azureTarget
+var failovers atomic.Int64
+
+// regionPattern matches the names of azure regions, the region is part of
+// the host the credentials are sent to
+var regionPattern = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// failoverTarget returns the failover target of the region.
+func failoverTarget(region string) (azureTarget, bool) {
+	for _, target := range failoverTargets {
+		if target.Region == region {
+			return target, true
+		}
+	}
+	return azureTarget{}, false
+}
+
+// knownRegion reports whether the region is configured on the server.
+func knownRegion(region string) bool {
+	if region == azureRegion {
+		return true
+	}
+	if _, ok := failoverTarget(region); ok {
+		return true
+	}
+	for _, target := range tenantCredentials {
+		if target.Region == region {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFailoverTargets(value string) ([]azureTarget, error) {
+	var targets []azureTarget
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		region, key, ok := strings.Cut(item, ":")
+		if !ok || region == "" || key == "" {
+			return nil, fmt.Errorf("expected region:key, got %q", item)
+		}
+		targets = append(targets, azureTarget{Region: region, Key: key})
+	}
+	return targets, nil
+}
+
+// tenantCredentials are the azure credentials used for the requests of an
+// api key instead of the server ones, by client name
+var tenantCredentials map[string]azureTarget
+
+func parseTenantCredentials(value string, clients map[string]string) (map[string]azureTarget, error) {
+	names := make(map[string]bool, len(clients))
+	for _, name := range clients {
+		names[name] = true
+	}
+
+	credentials := make(map[string]azureTarget)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, target, _ := strings.Cut(item, "=")
+		region, key, ok := strings.Cut(target, ":")
+		if !ok || name == "" || region == "" || key == "" {
+			return nil, fmt.Errorf("expected name=region:key, got %q", maskKey(item))
+		}
+		if !names[name] {
+			return nil, fmt.Errorf("%q is not the name of an api key", name)
+		}
+		credentials[name] = azureTarget{Region: region, Key: key}
+	}
+	return credentials, nil
+}
+
+// isServerKey reports whether the key is configured on the server, so
+// errors about it are not the fault of the client.
+func isServerKey(key string) bool {
+	if slices.Contains(serverKeys(), key) {
+		return true
+	}
+	for _, target := range tenantCredentials {
+		if key == target.Key {
+			return true
+		}
+	}
+	return false
+}
+
+// requestAzure sends the synthesis request to Azure. When the request uses
+// the server credentials and the region is down, the failover regions are
+// tried in order. The returned response always has a 200 status code.
+func requestAzure(ctx context.Context, ttsRequest TTSRequest) (*http.Response, error) {
+	resp, err := requestRegion(ctx, ttsRequest)
+	// custom voices are only deployed in their own region
+	if err == nil || ctx.Err() != nil || !azure.IsDown(err) || !usesServerKey(ttsRequest.AzureKey) || ttsRequest.DeploymentID != "" {
+		return resp, err
+	}
+
+	for _, target := range failoverTargets {
+		if target.Region == ttsRequest.AzureRegion {
+			continue
+		}
+		slog.Warn("Failing over to another region", "from", ttsRequest.AzureRegion, "to", target.Region, "error", err)
+		failovers.Add(1)
+
+		failover := ttsRequest
+		failover.AzureRegion = target.Region
+		failover.AzureKey = target.Key
+		resp, err = requestRegion(ctx, failover)
+		if err == nil || ctx.Err() != nil || !azure.IsDown(err) {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+// requestRegion sends the synthesis request to a single region, retrying
+// transient failures with jittered exponential backoff.
+func requestRegion(ctx context.Context, ttsRequest TTSRequest) (*http.Response, error) {
+	url := synthesisEndpoint(ttsRequest) + "/cognitiveservices/v1"
+	if ttsRequest.DeploymentID != "" {
+		url += "?deploymentId=" + neturl.QueryEscape(ttsRequest.DeploymentID)
+	}
+	requestBody := buildSSML(ttsRequest)
+
+	breaker := regionBreaker(ttsRequest.AzureRegion)
+	if !breaker.Allow() {
+		return nil, azure.ErrCircuitOpen
+	}
+
+	pooled := len(serverKeys()) > 1 && usesServerKey(ttsRequest.AzureKey)
+	var lastErr error
+	for attempt := 0; attempt <= azureRetries; attempt++ {
+		if key, ok := healthyServerKey(); attempt > 0 && pooled && !serverKeyPool.healthy(ttsRequest.AzureKey) && ok {
+			// another key of the same resource can take over right away
+			ttsRequest.AzureKey = key
+		} else if attempt > 0 {
+			delay := backoff(attempt, lastErr)
+			slog.Warn("Retrying azure request", "delay", delay, "error", lastErr)
+			select {
+			case <-ctx.Done():
+				breaker.Release()
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(requestBody))
+		if err != nil {
+			// azure wasn't reached, a probe has to end for the next one
+			breaker.Release()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/ssml+xml")
+		req.Header.Set("X-Microsoft-OutputFormat", azureOutputFormat(ttsRequest))
+		if err := azure.SetAuth(ctx, req.Header, ttsRequest.AzureRegion, ttsRequest.AzureKey); err != nil {
+			lastErr = err
+			continue
+		}
+		req.Header.Set("User-Agent", "node")
+
+		resp, err := azure.Client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				// the client went away, this isn't an azure failure
+				breaker.Release()
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			if pooled {
+				serverKeyPool.record(ttsRequest.AzureKey, nil)
+			}
+			breaker.Record(nil)
+			return resp, nil
+		}
+		lastErr = azure.ResponseError(resp)
+		resp.Body.Close()
+		if pooled {
+			serverKeyPool.record(ttsRequest.AzureKey, lastErr)
+			if _, ok := healthyServerKey(); ok && !serverKeyPool.healthy(ttsRequest.AzureKey) {
+				continue
+			}
+		}
+		if !azure.IsRetryable(resp.StatusCode) {
+			break
+		}
+	}
+
+	breaker.Record(lastErr)
+	return nil, lastErr
+}
+
+// regionBreaker returns the circuit breaker of the region, regions of the
+// clients get one of their own so they can't add breakers to the server.
+func regionBreaker(region string) *azure.Breaker {
+	if knownRegion(region) {
+		return azure.BreakerFor(region)
+	}
+	return &azure.Breaker{}
+}
+
+// synthesisEndpoint returns the base url for synthesis requests, custom
+// voices are served from a different one.
+func synthesisEndpoint(ttsRequest TTSRequest) string {
+	if ttsRequest.DeploymentID != "" {
+		return azure.RegionEndpoint(azure.Cloud.Voice, ttsRequest.AzureRegion)
+	}
+	return azure.RegionEndpoint(azure.Cloud.TTS, ttsRequest.AzureRegion)
+}
+
+func backoff(attempt int, lastErr error) time.Duration {
+	if azureErr, ok := lastErr.(*azure.Error); ok && azureErr.RetryAfter > 0 {
+		return min(azureErr.RetryAfter, maxRetryDelay)
+	}
+
+	delay := min(azureRetryDelay<<(attempt-1), maxRetryDelay)
+	// full jitter between half and the whole delay
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// azureFailed responds with the error azure returned, so clients can tell
+// bad credentials from throttling and invalid requests.
+func azureFailed(w http.ResponseWriter, ttsRequest TTSRequest, err error) bool {
+	var azureErr *azure.Error
+	if !errors.As(err, &azureErr) {
+		return false
+	}
+
+	switch azureErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if ttsRequest.AzureKey != "" && !isServerKey(ttsRequest.AzureKey) {
+			writeError(w, fieldError("invalid_credentials", "azureKey", "azure rejected the azureKey of the request: %s", err), http.StatusUnauthorized)
+			return true
+		}
+		// the client can't fix the server credentials
+		writeError(w, &apiError{Code: "azure_unauthorized", Message: "azure rejected the server credentials, check AZURE_KEY, AZURE_REGION and TENANT_AZURE_CREDENTIALS: " + err.Error()}, http.StatusBadGateway)
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(azureErr.RetryAfter.Seconds())), 1)))
+		writeError(w, &apiError{Code: "azure_throttled", Message: err.Error()}, http.StatusTooManyRequests)
+	case http.StatusBadRequest:
+		writeError(w, &apiError{Code: "azure_rejected", Message: err.Error()}, http.StatusBadRequest)
+	default:
+		writeError(w, &apiError{Code: "azure_error", Message: err.Error()}, http.StatusBadGateway)
+	}
+	return true
+}
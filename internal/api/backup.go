@@ -0,0 +1,451 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// backupURL is where the permanent cache is backed up every backupInterval:
+// a directory, s3://bucket/prefix or the url of an azure storage container
+var backupURL string
+var backupInterval time.Duration
+
+// backupRetention is how many backups are kept, older ones are deleted
+// after every backup
+var backupRetention int
+
+// backups is nil when backupURL isn't set
+var backups backupTarget
+
+// backupMu makes sure only one backup or restore runs at a time
+var backupMu sync.Mutex
+
+// backups are named after the time they were taken, so they sort by age
+const backupTimeFormat = "20060102T150405Z"
+
+var errNoBackups = errors.New("no backups found")
+
+// backupInfo is an archive of the permanent cache in the backup target.
+type backupInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// backupTarget stores the backup archives. List only returns the files
+// named by backupName, other files in the same place are left alone.
+type backupTarget interface {
+	Upload(ctx context.Context, name string, r io.Reader, size int64) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	List(ctx context.Context) ([]backupInfo, error)
+	Delete(ctx context.Context, name string) error
+}
+
+func setupBackups() error {
+	if backupURL == "" {
+		backups = nil
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(backupURL, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(backupURL, "s3://"), "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		client, err := newS3Client()
+		if err != nil {
+			return err
+		}
+		backups = &s3Backups{client: client, bucket: bucket, prefix: prefix}
+	case strings.HasPrefix(backupURL, "https://"), strings.HasPrefix(backupURL, "http://"):
+		container, err := azure.NewContainer(backupURL)
+		if err != nil {
+			return err
+		}
+		backups = &azureBackups{container: container}
+	default:
+		backups = dirBackups(strings.TrimPrefix(backupURL, "file://"))
+	}
+	return nil
+}
+
+func backupName(t time.Time) string {
+	return "cache-" + t.UTC().Format(backupTimeFormat) + ".tar.gz"
+}
+
+// parseBackupName returns when the backup was taken, ok is false for
+// names that weren't returned by backupName.
+func parseBackupName(name string) (time.Time, bool) {
+	timestamp, ok := strings.CutPrefix(name, "cache-")
+	if !ok {
+		return time.Time{}, false
+	}
+	timestamp, ok = strings.CutSuffix(timestamp, ".tar.gz")
+	if !ok {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(backupTimeFormat, timestamp)
+	return created, err == nil
+}
+
+// listBackups returns the backups newest first.
+func listBackups(ctx context.Context) ([]backupInfo, error) {
+	list, err := backups.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list, nil
+}
+
+// createBackup uploads an export of the permanent cache, encrypted when the
+// cache is, and deletes the backups beyond backupRetention.
+func createBackup(ctx context.Context) (backupInfo, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	// the archive is written to a file first, the targets need its size
+	file, err := os.CreateTemp(cacheDir, ".backup-*")
+	if err != nil {
+		return backupInfo{}, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := writeExport(file); err != nil {
+		return backupInfo{}, err
+	}
+
+	var body io.ReadSeeker = file
+	if cache.EncryptionEnabled() {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return backupInfo{}, err
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return backupInfo{}, err
+		}
+		body = bytes.NewReader(cache.Seal(data))
+	}
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return backupInfo{}, err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return backupInfo{}, err
+	}
+
+	created := time.Now().UTC().Truncate(time.Second)
+	info := backupInfo{Name: backupName(created), Size: size, Created: created}
+	if err := backups.Upload(ctx, info.Name, body, size); err != nil {
+		return backupInfo{}, err
+	}
+	slog.Info("Cache backed up", "name", info.Name, "bytes", size)
+
+	pruneBackups(ctx)
+	return info, nil
+}
+
+// pruneBackups deletes the oldest backups, keeping backupRetention of them.
+func pruneBackups(ctx context.Context) {
+	if backupRetention <= 0 {
+		return
+	}
+	list, err := listBackups(ctx)
+	if err != nil {
+		slog.Error("Failed to list backups", "error", err)
+		return
+	}
+	for _, backup := range list[min(backupRetention, len(list)):] {
+		if err := backups.Delete(ctx, backup.Name); err != nil {
+			slog.Error("Failed to delete old backup", "name", backup.Name, "error", err)
+			continue
+		}
+		slog.Info("Deleted old backup", "name", backup.Name)
+	}
+}
+
+// restoreBackup imports the entries of the backup into the permanent cache,
+// the newest backup when name is empty. Entries that aren't in the backup
+// are kept.
+func restoreBackup(ctx context.Context, name string) (string, int, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if name == "" {
+		list, err := listBackups(ctx)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(list) == 0 {
+			return "", 0, errNoBackups
+		}
+		name = list[0].Name
+	} else if _, ok := parseBackupName(name); !ok {
+		return "", 0, fmt.Errorf("invalid backup name %q", name)
+	}
+
+	body, err := backups.Open(ctx, name)
+	if err != nil {
+		return name, 0, err
+	}
+	defer body.Close()
+
+	var r io.Reader = bufio.NewReader(body)
+	prefix, _ := r.(*bufio.Reader).Peek(8)
+	if cache.Sealed(prefix) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return name, 0, err
+		}
+		data, err = cache.Unseal(data)
+		if err != nil {
+			return name, 0, err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	restored, err := readImport(r)
+	if persist && restored > 0 {
+		saveCache()
+	}
+	if err != nil {
+		return name, restored, err
+	}
+	slog.Info("Cache restored from backup", "name", name, "entries", restored)
+	return name, restored, nil
+}
+
+// runBackups backs up the cache every interval.
+func runBackups(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := createBackup(ctx); err != nil {
+				slog.Error("Failed to back up cache", "error", err)
+			}
+		}
+	}
+}
+
+func handleListBackups(w http.ResponseWriter, r *http.Request) {
+	if backups == nil {
+		httpError(w, "backups are disabled, set BACKUP_URL to enable them", http.StatusNotFound)
+		return
+	}
+	list, err := listBackups(r.Context())
+	if err != nil {
+		httpError(w, "failed to list backups: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"backups": append([]backupInfo{}, list...),
+	})
+}
+
+func handleCreateBackup(w http.ResponseWriter, r *http.Request) {
+	if backups == nil {
+		httpError(w, "backups are disabled, set BACKUP_URL to enable them", http.StatusNotFound)
+		return
+	}
+	info, err := createBackup(r.Context())
+	if err != nil {
+		httpError(w, "backup failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+type restoreRequest struct {
+	// Name is the backup to restore, the newest one when it's empty
+	Name string `json:"name"`
+}
+
+func handleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if backups == nil {
+		httpError(w, "backups are disabled, set BACKUP_URL to enable them", http.StatusNotFound)
+		return
+	}
+	var request restoreRequest
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &request); err != nil && err != io.EOF {
+			bodyError(w, err)
+			return
+		}
+	}
+
+	if _, ok := parseBackupName(request.Name); request.Name != "" && !ok {
+		writeError(w, fieldError("invalid_backup", "name", "invalid backup name %q", request.Name), http.StatusBadRequest)
+		return
+	}
+
+	name, restored, err := restoreBackup(r.Context(), request.Name)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errNoBackups) || errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		httpError(w, fmt.Sprintf("restore failed after %d entries: %s", restored, err), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":     name,
+		"restored": restored,
+	})
+}
+
+// dirBackups writes the backups to a directory, e.g. a mounted network share.
+type dirBackups string
+
+func (d dirBackups) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return err
+	}
+	return cache.WriteFileAtomic(filepath.Join(string(d), name), func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+func (d dirBackups) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func (d dirBackups) List(ctx context.Context) ([]backupInfo, error) {
+	files, err := os.ReadDir(string(d))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var list []backupInfo
+	for _, file := range files {
+		created, ok := parseBackupName(file.Name())
+		if !ok {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		list = append(list, backupInfo{Name: file.Name(), Size: info.Size(), Created: created})
+	}
+	return list, nil
+}
+
+func (d dirBackups) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// s3Backups writes the backups to an s3 bucket with the s3 settings of the
+// cache backend.
+type s3Backups struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3Backups) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.prefix+name, r, size, minio.PutObjectOptions{ContentType: "application/gzip"})
+	return err
+}
+
+func (s *s3Backups) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// the request is only sent on the first read, stat it to fail here
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+func (s *s3Backups) List(ctx context.Context) ([]backupInfo, error) {
+	var list []backupInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, s.prefix)
+		if created, ok := parseBackupName(name); ok {
+			list = append(list, backupInfo{Name: name, Size: object.Size, Created: created})
+		}
+	}
+	return list, nil
+}
+
+func (s *s3Backups) Delete(ctx context.Context, name string) error {
+	return s.client.RemoveObject(ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+// azureBackups writes the backups to backups/ in an azure storage container.
+type azureBackups struct {
+	container *azure.Container
+}
+
+func (a *azureBackups) Upload(ctx context.Context, name string, r io.Reader, size int64) error {
+	return a.container.Put(ctx, "backups/"+name, r, size, http.Header{"Content-Type": {"application/gzip"}})
+}
+
+func (a *azureBackups) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return a.container.Open(ctx, "backups/"+name)
+}
+
+func (a *azureBackups) List(ctx context.Context) ([]backupInfo, error) {
+	var list []backupInfo
+	marker := ""
+	for {
+		blobs, next, err := a.container.List(ctx, "backups/", marker, 5000)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range blobs {
+			name := strings.TrimPrefix(blob.Name, "backups/")
+			if created, ok := parseBackupName(name); ok {
+				list = append(list, backupInfo{Name: name, Size: blob.Properties.ContentLength, Created: created})
+			}
+		}
+		if next == "" {
+			return list, nil
+		}
+		marker = next
+	}
+}
+
+func (a *azureBackups) Delete(ctx context.Context, name string) error {
+	return a.container.Delete(ctx, "backups/"+name)
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// jobs with more characters than this are sent to the azure batch synthesis
+// api instead of the real-time one, 0 disables it
+var batchThreshold int64
+var batchPollInterval = 10 * time.Second
+var batchTimeout = time.Hour
+
+const batchAPIVersion = "2024-04-01"
+
+type batchSynthesis struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Outputs struct {
+		Result string `json:"result"`
+	} `json:"outputs"`
+	Properties struct {
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"properties"`
+}
+
+func useBatch(ttsRequest TTSRequest) bool {
+	return batchThreshold > 0 && ttsRequest.Provider == "azure" && ttsRequest.Format == "" && requestChars(ttsRequest) > batchThreshold
+}
+
+// synthesizeBatch submits the request to the azure batch synthesis api,
+// waits for it to finish and stores the downloaded audio in the cache.
+func synthesizeBatch(ctx context.Context, ttsRequest TTSRequest, key string) (cache.Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, batchTimeout)
+	defer cancel()
+
+	start := time.Now()
+	id := newJobID()
+	synthesisBody := map[string]interface{}{
+		"inputKind": "SSML",
+		"inputs":    []map[string]string{{"content": buildSSML(ttsRequest)}},
+		"properties": map[string]interface{}{
+			"outputFormat":      outputFormat,
+			"concatenateResult": true,
+			"timeToLiveInHours": 24,
+		},
+	}
+	if ttsRequest.DeploymentID != "" {
+		synthesisBody["customVoices"] = map[string]string{ttsRequest.Name: ttsRequest.DeploymentID}
+	}
+	body, err := json.Marshal(synthesisBody)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+
+	synthesis, err := batchRequest(ctx, http.MethodPut, ttsRequest, id, body)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	defer batchRequest(context.WithoutCancel(ctx), http.MethodDelete, ttsRequest, id, nil)
+
+	for synthesis.Status != "Succeeded" {
+		if synthesis.Status == "Failed" {
+			if synthesis.Properties.Error != nil {
+				return cache.Entry{}, fmt.Errorf("batch synthesis failed: %s", synthesis.Properties.Error.Message)
+			}
+			return cache.Entry{}, errors.New("batch synthesis failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return cache.Entry{}, ctx.Err()
+		case <-time.After(batchPollInterval):
+		}
+
+		synthesis, err = batchRequest(ctx, http.MethodGet, ttsRequest, id, nil)
+		if err != nil {
+			return cache.Entry{}, err
+		}
+	}
+
+	audio, err := downloadBatchResult(ctx, synthesis.Outputs.Result)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	requestInfoFrom(ctx).AzureLatency = time.Since(start)
+
+	entry, err := normalizeEntry(ctx, cache.Entry{
+		Audio:    audio,
+		Type:     "audio/mpeg",
+		Text:     requestText(ttsRequest),
+		Voice:    ttsRequest.Name,
+		Language: ttsRequest.Language,
+		Created:  time.Now(),
+	}, ttsRequest)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	storeEntry(ttsRequest, key, entry)
+	usage.add(clientName(ctx), requestChars(ttsRequest))
+
+	return entry, nil
+}
+
+func batchRequest(ctx context.Context, method string, ttsRequest TTSRequest, id string, body []byte) (*batchSynthesis, error) {
+	url := fmt.Sprintf("%s/texttospeech/batchsyntheses/%s?api-version=%s", azure.RegionEndpoint(azure.Cloud.API, ttsRequest.AzureRegion), id, batchAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := azure.SetAuth(ctx, req.Header, ttsRequest.AzureRegion, ttsRequest.AzureKey); err != nil {
+		return nil, err
+	}
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, azure.ResponseError(resp)
+	}
+	if method == http.MethodDelete {
+		return nil, nil
+	}
+
+	var synthesis batchSynthesis
+	if err := json.NewDecoder(resp.Body).Decode(&synthesis); err != nil {
+		return nil, err
+	}
+	return &synthesis, nil
+}
+
+// downloadBatchResult downloads the result zip and returns the audio file in it.
+func downloadBatchResult(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, azure.ResponseError(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range archive.File {
+		if strings.ToLower(path.Ext(file.Name)) != ".mp3" {
+			continue
+		}
+		audio, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer audio.Close()
+		return io.ReadAll(audio)
+	}
+	return nil, errors.New("batch synthesis result has no audio")
+}
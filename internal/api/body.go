@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxBodyBytes limits the size of json request bodies
+var maxBodyBytes int64 = 1 << 20
+
+// maxTextLength limits the characters of the text, ssml or segments of
+// a request, 0 means no limit
+var maxTextLength int
+
+// readJSON decodes the json body of the request into v, rejecting fields
+// v doesn't have so typos in the request aren't silently ignored. An empty
+// body is returned as io.EOF.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil || err == io.EOF:
+		return err
+	case errors.As(err, &tooLarge):
+		return &apiError{Code: "request_too_large", Message: fmt.Sprintf("request body can't be larger than %d bytes", tooLarge.Limit)}
+	case errors.As(err, &typeErr):
+		return fieldError("invalid_type", typeErr.Field, "%s must be %s", typeErr.Field, typeErr.Type)
+	}
+	// the decoder has no error type for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		field = strings.Trim(field, `"`)
+		return fieldError("unknown_field", field, "unknown field %s", field)
+	}
+	return &apiError{Code: "invalid_json", Message: "invalid json: " + err.Error()}
+}
+
+// bodyError responds with the error of readJSON.
+func bodyError(w http.ResponseWriter, err error) {
+	if err == io.EOF {
+		err = &apiError{Code: "invalid_json", Message: "request body is empty"}
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Code == "request_too_large" {
+		writeError(w, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	writeError(w, err, http.StatusBadRequest)
+}
+
+// textLength returns the number of characters that are synthesized.
+func textLength(ttsRequest TTSRequest) int {
+	length := len([]rune(ttsRequest.Text)) + len([]rune(ttsRequest.SSML))
+	for _, segment := range ttsRequest.Segments {
+		length += len([]rune(segment.Text))
+	}
+	return length
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+const maxCheckRequests = 1000
+
+// httpCacheControl is the Cache-Control header of audio in the permanent
+// cache, the Expires header is set from its max-age
+var httpCacheControl string
+var httpCacheMaxAge time.Duration
+
+var maxAgeDirective = regexp.MustCompile(`(?:^|[\s,])max-age=(\d+)`)
+
+func parseCacheControl(value string) time.Duration {
+	match := maxAgeDirective.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+	seconds, _ := strconv.Atoi(match[1])
+	return time.Duration(seconds) * time.Second
+}
+
+// cachedAudio describes the cached audio of a request
+type cachedAudio struct {
+	Key         string `json:"key,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	Permanent   bool   `json:"permanent"`
+	// ExpiresAt is set for entries that expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	created   time.Time
+	etag      string
+}
+
+// lookupCached reports whether the audio of a prepared request is cached,
+// without synthesizing it. Split requests are cached when all of their
+// sentences are, they don't have a key of their own.
+func lookupCached(ttsRequest TTSRequest) (cachedAudio, bool) {
+	if !ttsRequest.Split {
+		key := cacheKey(ttsRequest)
+		audio := cachedAudio{Key: key}
+		entry, permanent := c.Get(key)
+		if !permanent {
+			var ok bool
+			if entry, ok = tempC.Get(key); !ok {
+				return audio, false
+			}
+		}
+		audio.ContentType = entry.Type
+		audio.Size = cache.Size(key, entry) - int64(len(key))
+		audio.Permanent = permanent
+		audio.created = entry.Created
+		if !entry.Expires.IsZero() {
+			audio.ExpiresAt = &entry.Expires
+		}
+		audio.etag = `"` + cache.Hash(entry) + `"`
+		return audio, true
+	}
+
+	audio := cachedAudio{Permanent: true}
+	for _, part := range splitParts(ttsRequest) {
+		partAudio, ok := lookupCached(part)
+		if !ok {
+			return cachedAudio{}, false
+		}
+		audio.ContentType = partAudio.ContentType
+		audio.Size += partAudio.Size
+		audio.Permanent = audio.Permanent && partAudio.Permanent
+	}
+	return audio, true
+}
+
+// cacheStatus is the X-Cache header of audio served from the permanent or the temporary cache.
+func cacheStatus(permanent bool) string {
+	if permanent {
+		return "HIT"
+	}
+	return "TEMP-HIT"
+}
+
+// setCacheHeaders tells the client that the audio came from the cache and when it was synthesized.
+func setCacheHeaders(w http.ResponseWriter, created time.Time, permanent bool) {
+	w.Header().Set("X-Cache", cacheStatus(permanent))
+	if !created.IsZero() {
+		w.Header().Set("X-Cache-Date", created.UTC().Format(http.TimeFormat))
+		w.Header().Set("Age", strconv.Itoa(int(max(time.Since(created), 0).Seconds())))
+	}
+}
+
+// notModified sets the ETag of the audio and responds with 304 if the
+// client already has it.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether the If-None-Match header contains the etag,
+// weak validators match too as the header is only used for GET and HEAD.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheControl lets http caches in front of the proxy store audio from the
+// permanent cache, audio that is only cached temporarily must not be stored.
+func setCacheControl(w http.ResponseWriter, permanent bool) {
+	if httpCacheControl == "" {
+		return
+	}
+	if !permanent {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	w.Header().Set("Cache-Control", httpCacheControl)
+	if httpCacheMaxAge > 0 {
+		w.Header().Set("Expires", time.Now().Add(httpCacheMaxAge).UTC().Format(http.TimeFormat))
+	}
+}
+
+// headTTS responds to HEAD /tts with the headers of the cached audio,
+// or 404 if it's not cached. The audio is never synthesized.
+func headTTS(w http.ResponseWriter, r *http.Request, ttsRequest TTSRequest) {
+	if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	audio, ok := lookupCached(ttsRequest)
+	if audio.Key != "" {
+		w.Header().Set("X-Cache-Key", audio.Key)
+	}
+	if !ok {
+		w.Header().Set("X-Cache", "MISS")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	setCacheHeaders(w, audio.created, audio.Permanent)
+	setCacheControl(w, audio.Permanent)
+	if audio.etag != "" && notModified(w, r, audio.etag) {
+		return
+	}
+	w.Header().Set("Content-Type", audio.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(audio.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+type checkResult struct {
+	cachedAudio
+	Cached bool   `json:"cached"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleCheckCache reports which of the requests are cached, in the same
+// order, so clients can warm only the missing ones.
+func handleCheckCache(w http.ResponseWriter, r *http.Request) {
+	var requests []TTSRequest
+	if err := readJSON(w, r, &requests); err != nil {
+		bodyError(w, err)
+		return
+	}
+	if len(requests) > maxCheckRequests {
+		httpError(w, fmt.Sprintf("at most %d requests can be checked at once", maxCheckRequests), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]checkResult, len(requests))
+	for i, ttsRequest := range requests {
+		if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].cachedAudio, results[i].Cached = lookupCached(ttsRequest)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+	})
+}
+
+// handleRefreshCache synthesizes the request again and replaces the cached
+// audio, e.g. after azure updated the pronunciation of a voice.
+func handleRefreshCache(w http.ResponseWriter, r *http.Request) {
+	var ttsRequest TTSRequest
+	if err := readJSON(w, r, &ttsRequest); err != nil {
+		bodyError(w, err)
+		return
+	}
+	if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := validateVoice(ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	parts := []TTSRequest{ttsRequest}
+	if ttsRequest.Split {
+		parts = splitParts(ttsRequest)
+	}
+	for _, part := range parts {
+		if _, err := refreshEntry(r.Context(), part, cacheKey(part)); err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			if errors.Is(err, azure.ErrCircuitOpen) {
+				writeError(w, err, http.StatusServiceUnavailable)
+				return
+			}
+			if !synthesisBusy(w, err) && !azureFailed(w, ttsRequest, err) {
+				writeError(w, err, http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	audio, _ := lookupCached(ttsRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audio)
+}
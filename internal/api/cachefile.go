@@ -0,0 +1,304 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// cacheFileMagic starts every cache file written with a header, files
+// without it are the raw gob files of older versions
+var cacheFileMagic = []byte("TTSC")
+
+// cacheFileVersion is the version of the data after the header. Bump it
+// when the format changes and keep decoding the older versions in
+// decodeCacheFile, so the cache doesn't have to be wiped.
+//
+// Version 2 writes the entries in blocks that are compressed and encrypted
+// on their own. Blocks and entries have a checksum, so a corrupted part of
+// the file only loses the entries in it.
+const cacheFileVersion = 2
+
+// cacheBlockSize is about how many bytes of entries are put in a block
+const cacheBlockSize = 256 << 10
+
+// cacheCompression is how the cache file is compressed: zstd, gzip or none
+var cacheCompression string
+
+var cacheCompressions = map[string]byte{"none": 0, "gzip": 1, "zstd": 2}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+var errChecksum = errors.New("checksum mismatch")
+
+// cacheRecord is an entry in a block of the cache file.
+type cacheRecord struct {
+	Key   string
+	Entry cache.Entry
+}
+
+// encodeCacheFile returns the cache file with the header, the compression
+// and the version it was written with.
+func encodeCacheFile(items map[string]gocache.Item) ([]byte, error) {
+	compression, ok := cacheCompressions[cacheCompression]
+	if !ok {
+		compression = cacheCompressions["zstd"]
+	}
+
+	var file bytes.Buffer
+	file.Write(cacheFileMagic)
+	file.WriteByte(cacheFileVersion)
+	file.WriteByte(compression)
+
+	var block bytes.Buffer
+	flush := func() error {
+		if block.Len() == 0 {
+			return nil
+		}
+		var compressed bytes.Buffer
+		w, err := compressWriter(compression, &compressed)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(block.Bytes()); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		writeFrame(&file, cache.Seal(compressed.Bytes()))
+		block.Reset()
+		return nil
+	}
+
+	for key, item := range items {
+		entry, ok := item.Object.(cache.Entry)
+		if !ok {
+			continue
+		}
+		var record bytes.Buffer
+		if err := gob.NewEncoder(&record).Encode(cacheRecord{Key: key, Entry: entry}); err != nil {
+			return nil, err
+		}
+		writeFrame(&block, record.Bytes())
+		if block.Len() >= cacheBlockSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return file.Bytes(), nil
+}
+
+// decodeCacheFile reads the cache file of any version, outdated is set when
+// it wasn't written with the current version and compression. Corrupted
+// blocks and entries are skipped and counted in damaged, an error is only
+// returned when nothing could be read.
+func decodeCacheFile(data []byte) (items map[string]gocache.Item, outdated bool, damaged int, err error) {
+	version, compression := 0, byte(0)
+	if bytes.HasPrefix(data, cacheFileMagic) {
+		header := data[len(cacheFileMagic):]
+		if len(header) < 2 {
+			return nil, false, 0, errors.New("cache file header is truncated")
+		}
+		version, compression = int(header[0]), header[1]
+		data = header[2:]
+	}
+	outdated = version != cacheFileVersion || compression != cacheCompressions[cacheCompression]
+
+	switch version {
+	case 0, 1:
+		// version 1 only added the header to the gob data, it can only be
+		// read as a whole
+		r, err := decompressReader(compression, bytes.NewReader(data))
+		if err != nil {
+			return nil, false, 0, err
+		}
+		defer r.Close()
+		if err := gob.NewDecoder(r).Decode(&items); err != nil {
+			return nil, false, 0, err
+		}
+		return items, outdated, 0, nil
+	case 2:
+		items = make(map[string]gocache.Item)
+		var lastErr error
+		for len(data) > 0 {
+			stored, size, err := readFrame(data)
+			if err != nil && !blockEnds(data, size) {
+				// the length is damaged, the blocks after it are found
+				// again by their checksums
+				if next := nextCacheBlock(compression, data, 1); next < len(data) {
+					damaged++
+					data = data[next:]
+					continue
+				}
+				// nothing intact follows, the block is cut off
+				if len(data) > 8 {
+					stored = data[8:]
+				}
+				size = 0
+			}
+			// the entries of a block with a wrong checksum or a block that
+			// is cut off are still read, they have checksums of their own
+			n, blockErr := decodeCacheBlock(compression, stored, items)
+			if blockErr != nil {
+				n, lastErr = 1, blockErr
+			} else if err != nil && n == 0 {
+				n = 1
+			}
+			damaged += n
+			if size == 0 {
+				break
+			}
+			data = data[size:]
+		}
+		if len(items) == 0 && lastErr != nil {
+			return nil, false, 0, lastErr
+		}
+		return items, outdated, damaged, nil
+	default:
+		return nil, false, 0, fmt.Errorf("cache file version %d is newer than this server supports", version)
+	}
+}
+
+// blockEnds reports whether the frame of the given size ends where the next
+// intact block or the file starts, so only its data is damaged.
+func blockEnds(data []byte, size int) bool {
+	if size == 0 {
+		return false
+	}
+	if size == len(data) {
+		return true
+	}
+	_, _, err := readFrame(data[size:])
+	return err == nil
+}
+
+// nextCacheBlock returns the offset of the next intact block from offset
+// on, or the length of data when there's none. The entries in a block are
+// framed too, so the frame has to decode as a block.
+func nextCacheBlock(compression byte, data []byte, offset int) int {
+	for ; offset < len(data); offset++ {
+		stored, _, err := readFrame(data[offset:])
+		if err != nil {
+			continue
+		}
+		if n, err := decodeCacheBlock(compression, stored, make(map[string]gocache.Item)); n == 0 && err == nil {
+			return offset
+		}
+	}
+	return len(data)
+}
+
+// decodeCacheBlock adds the entries of the block to items, it returns how
+// many of them were corrupted.
+func decodeCacheBlock(compression byte, stored []byte, items map[string]gocache.Item) (int, error) {
+	data, err := cache.Unseal(stored)
+	if err != nil {
+		return 0, err
+	}
+	r, err := decompressReader(compression, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	// the entries before a decompression error are still used
+	block, readErr := io.ReadAll(r)
+
+	damaged := 0
+	for len(block) > 0 {
+		payload, size, err := readFrame(block)
+		if size == 0 {
+			damaged++
+			break
+		}
+		block = block[size:]
+		var record cacheRecord
+		if err == nil {
+			err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&record)
+		}
+		if err != nil || record.Key == "" {
+			damaged++
+			continue
+		}
+		items[record.Key] = gocache.Item{Object: record.Entry}
+	}
+	if readErr != nil {
+		damaged++
+	}
+	return damaged, nil
+}
+
+// writeFrame writes data with its length and checksum in front.
+func writeFrame(buffer *bytes.Buffer, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(data, crc32c))
+	buffer.Write(header[:])
+	buffer.Write(data)
+}
+
+// readFrame returns the data of the frame written by writeFrame at the start
+// of data and the size of the whole frame. The data is also returned when
+// the checksum doesn't match, and what's left of it with a size of 0 when
+// the frame is cut off.
+func readFrame(data []byte) ([]byte, int, error) {
+	if len(data) < 8 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if len(data)-8 < size {
+		return data[8:], 0, io.ErrUnexpectedEOF
+	}
+	payload := data[8 : 8+size]
+	if crc32.Checksum(payload, crc32c) != binary.BigEndian.Uint32(data[4:]) {
+		return payload, 8 + size, errChecksum
+	}
+	return payload, 8 + size, nil
+}
+
+func compressWriter(compression byte, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case cacheCompressions["gzip"]:
+		return gzip.NewWriter(w), nil
+	case cacheCompressions["zstd"]:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+func decompressReader(compression byte, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case cacheCompressions["none"]:
+		return io.NopCloser(r), nil
+	case cacheCompressions["gzip"]:
+		return gzip.NewReader(r)
+	case cacheCompressions["zstd"]:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown cache file compression %d", compression)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
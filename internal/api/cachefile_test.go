@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// testCacheFile returns a cache file with an entry per block and the offsets
+// of the blocks in it.
+func testCacheFile(t *testing.T, compression string, keys ...string) ([]byte, []int) {
+	t.Helper()
+	oldCompression := cacheCompression
+	cacheCompression = compression
+	t.Cleanup(func() { cacheCompression = oldCompression })
+
+	items := make(map[string]gocache.Item)
+	for i, key := range keys {
+		// every entry fills a block of its own
+		audio := bytes.Repeat([]byte{byte(i)}, cacheBlockSize)
+		items[key] = gocache.Item{Object: cache.Entry{Audio: audio, Text: key}}
+	}
+	data, err := encodeCacheFile(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offsets []int
+	for offset := len(cacheFileMagic) + 2; offset < len(data); {
+		_, size, err := readFrame(data[offset:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+		offset += size
+	}
+	if len(offsets) != len(keys) {
+		t.Fatalf("cache file has %d blocks, want %d", len(offsets), len(keys))
+	}
+	return data, offsets
+}
+
+func TestDecodeCacheFileDamagedLength(t *testing.T) {
+	for name, damage := range map[string]func(length []byte){
+		// the block seems to go past the end of the file
+		"too long": func(length []byte) { length[0] ^= 0xff },
+		// the block seems to end in the middle of itself
+		"too short": func(length []byte) {
+			binary.BigEndian.PutUint32(length, binary.BigEndian.Uint32(length)-100)
+		},
+	} {
+		for _, compression := range []string{"none", "zstd"} {
+			t.Run(name+"/"+compression, func(t *testing.T) {
+				data, offsets := testCacheFile(t, compression, "a", "b", "c", "d")
+				damage(data[offsets[1] : offsets[1]+4])
+
+				items, _, damaged, err := decodeCacheFile(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(items) != 3 {
+					t.Errorf("read %d entries, want the 3 in the intact blocks", len(items))
+				}
+				if damaged == 0 {
+					t.Error("the damaged block wasn't counted")
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeCacheFileDamagedTail(t *testing.T) {
+	data, offsets := testCacheFile(t, "none", "a", "b", "c")
+	last := data[offsets[2] : offsets[2]+4]
+	binary.BigEndian.PutUint32(last, binary.BigEndian.Uint32(last)+100)
+
+	items, _, damaged, err := decodeCacheFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the entries of a block that is cut off are still read
+	if len(items) != 3 || damaged == 0 {
+		t.Errorf("read %d entries with %d damaged, want 3 and the damaged block", len(items), damaged)
+	}
+}
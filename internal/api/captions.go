@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+const maxCaptionChars = 42
+const maxCaptionDuration = 5000
+
+type caption struct {
+	start int64
+	end   int64
+	text  string
+}
+
+// buildCaptions groups the words into captions, breaking after sentences and
+// when a caption gets too long.
+func buildCaptions(words []cache.WordBoundary) []caption {
+	var captions []caption
+	var current *caption
+	for _, word := range words {
+		punctuation := strings.IndexFunc(word.Text, func(r rune) bool { return !unicode.IsPunct(r) }) < 0
+		if current != nil && !punctuation &&
+			(len([]rune(current.text))+1+len([]rune(word.Text)) > maxCaptionChars || word.Offset+word.Duration-current.start > maxCaptionDuration) {
+			captions = append(captions, *current)
+			current = nil
+		}
+
+		if current == nil {
+			if punctuation && len(captions) > 0 {
+				// punctuation belongs to the previous caption
+				captions[len(captions)-1].text += word.Text
+				continue
+			}
+			current = &caption{start: word.Offset, text: word.Text}
+		} else if punctuation {
+			current.text += word.Text
+		} else {
+			current.text += " " + word.Text
+		}
+		current.end = max(current.end, word.Offset+word.Duration)
+
+		if strings.ContainsAny(word.Text, ".!?…") {
+			captions = append(captions, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		captions = append(captions, *current)
+	}
+	return captions
+}
+
+func formatCaptions(captions []caption, format string) string {
+	var b strings.Builder
+	if format == "vtt" {
+		b.WriteString("WEBVTT\n\n")
+	}
+	for i, c := range captions {
+		if format == "srt" {
+			fmt.Fprintf(&b, "%d\n", i+1)
+		}
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", captionTime(c.start, format), captionTime(c.end, format), c.text)
+	}
+	return b.String()
+}
+
+func captionTime(ms int64, format string) string {
+	separator := "."
+	if format == "srt" {
+		separator = ","
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
+
+// handleCaptionsRequest responds with srt or vtt (?format=vtt) captions
+// matching the audio /tts returns for the same request.
+func handleCaptionsRequest(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "srt"
+	}
+	if format != "srt" && format != "vtt" {
+		httpError(w, "format must be srt or vtt", http.StatusBadRequest)
+		return
+	}
+
+	entry, _, ok := timedEntry(w, r, false)
+	if !ok {
+		return
+	}
+
+	if format == "vtt" {
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-subrip; charset=utf-8")
+	}
+	w.Write([]byte(formatCaptions(buildCaptions(entry.Words), format)))
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// synthesisSlots limits how many synthesis requests are sent to the provider
+// at the same time, nil means no limit
+var synthesisSlots chan struct{}
+
+// synthesisQueueTimeout is how long a request waits for a free slot,
+// 0 rejects it right away
+var synthesisQueueTimeout time.Duration
+
+var errSynthesisBusy = &apiError{Code: "synthesis_busy", Message: "too many concurrent synthesis requests, try again later"}
+
+// acquireSynthesisSlot waits for a free slot, the returned function releases it.
+func acquireSynthesisSlot(ctx context.Context) (func(), error) {
+	if synthesisSlots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-synthesisSlots }
+
+	select {
+	case synthesisSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if synthesisQueueTimeout <= 0 {
+		return nil, errSynthesisBusy
+	}
+
+	timer := time.NewTimer(synthesisQueueTimeout)
+	defer timer.Stop()
+	select {
+	case synthesisSlots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, errSynthesisBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// synthesisBusy responds with 429 if err is errSynthesisBusy.
+func synthesisBusy(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, errSynthesisBusy) {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	writeError(w, err, http.StatusTooManyRequests)
+	return true
+}
@@ -0,0 +1,653 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"gopkg.in/yaml.v3"
+)
+
+// Config has the settings of the server, see the README for what they do.
+// The keys in the config file are the environment variable names in lower case.
+type Config struct {
+	Port       string `yaml:"port"`
+	GRPCPort   string `yaml:"grpc_port"`
+	SocketPath string `yaml:"socket_path"`
+
+	TLSCert            string `yaml:"tls_cert"`
+	TLSKey             string `yaml:"tls_key"`
+	TLSAutocertDomains string `yaml:"tls_autocert_domains"`
+	TLSAutocertEmail   string `yaml:"tls_autocert_email"`
+
+	CacheBackend  string        `yaml:"cache_backend"`
+	CacheDir      string        `yaml:"cache_dir"`
+	CacheFile     string        `yaml:"cache_file"`
+	BlobDir       string        `yaml:"blob_dir"`
+	BoltFile      string        `yaml:"bolt_file"`
+	RedisURL      string        `yaml:"redis_url"`
+	StorageURL    string        `yaml:"azure_storage_url"`
+	PersistCache  bool          `yaml:"persist_cache"`
+	Compression   string        `yaml:"cache_compression"`
+	SaveInterval  time.Duration `yaml:"save_interval"`
+	CacheWAL      bool          `yaml:"cache_wal"`
+	SnapshotEvery time.Duration `yaml:"snapshot_interval"`
+	MaxCacheBytes int64         `yaml:"max_cache_bytes"`
+	MaxCacheItems int64         `yaml:"max_cache_items"`
+	CacheTTL      time.Duration `yaml:"cache_ttl"`
+	MaxCacheTTL   time.Duration `yaml:"max_cache_ttl"`
+	TempCacheTTL  time.Duration `yaml:"temp_cache_ttl"`
+	SlidingTTL    bool          `yaml:"sliding_ttl"`
+	HotCacheBytes int64         `yaml:"hot_cache_bytes"`
+	HotCacheItems int64         `yaml:"hot_cache_items"`
+
+	CacheEncryptionKey       string `yaml:"cache_encryption_key"`
+	CacheEncryptionKeySecret string `yaml:"cache_encryption_key_secret"`
+
+	S3Endpoint  string `yaml:"s3_endpoint"`
+	S3Region    string `yaml:"s3_region"`
+	S3Bucket    string `yaml:"s3_bucket"`
+	S3Prefix    string `yaml:"s3_prefix"`
+	S3AccessKey string `yaml:"s3_access_key"`
+	S3SecretKey string `yaml:"s3_secret_key"`
+
+	BackupURL       string        `yaml:"backup_url"`
+	BackupInterval  time.Duration `yaml:"backup_interval"`
+	BackupRetention int64         `yaml:"backup_retention"`
+
+	AzureKey               string        `yaml:"azure_key"`
+	AzureRegion            string        `yaml:"azure_region"`
+	AzureAuth              string        `yaml:"azure_auth"`
+	AzureSpeechResourceID  string        `yaml:"azure_speech_resource_id"`
+	AzureCloud             string        `yaml:"azure_cloud"`
+	AzureTTSEndpoint       string        `yaml:"azure_tts_endpoint"`
+	AzureVoiceEndpoint     string        `yaml:"azure_voice_endpoint"`
+	AzureSTTEndpoint       string        `yaml:"azure_stt_endpoint"`
+	AzureAPIEndpoint       string        `yaml:"azure_api_endpoint"`
+	AzureAuthorityHost     string        `yaml:"azure_authority_host"`
+	AzureKeyVaultURL       string        `yaml:"azure_key_vault_url"`
+	AzureKeyVaultSecret    string        `yaml:"azure_key_vault_secret"`
+	AzureKeyVaultRefresh   time.Duration `yaml:"azure_key_vault_refresh"`
+	AzureTimeout           time.Duration `yaml:"azure_timeout"`
+	AzureRetries           int64         `yaml:"azure_retries"`
+	AzureRetryDelay        time.Duration `yaml:"azure_retry_delay"`
+	AzureFailover          string        `yaml:"azure_failover"`
+	TenantAzureCredentials string        `yaml:"tenant_azure_credentials"`
+	AllowClientCredentials bool          `yaml:"allow_client_credentials"`
+
+	CircuitBreakerThreshold int64         `yaml:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  time.Duration `yaml:"circuit_breaker_cooldown"`
+	MaxConcurrentSynthesis  int64         `yaml:"max_concurrent_synthesis"`
+	SynthesisQueueTimeout   time.Duration `yaml:"synthesis_queue_timeout"`
+	FailureCacheTTL         time.Duration `yaml:"failure_cache_ttl"`
+	CacheSoftTTL            time.Duration `yaml:"cache_soft_ttl"`
+	ReadyCheckAzure         bool          `yaml:"ready_check_azure"`
+
+	HTTPCacheControl string `yaml:"http_cache_control"`
+	CORSOrigins      string `yaml:"cors_origins"`
+	CORSMethods      string `yaml:"cors_methods"`
+	CORSHeaders      string `yaml:"cors_headers"`
+
+	AllowMarkup     bool   `yaml:"allow_markup"`
+	MaxBodyBytes    int64  `yaml:"max_body_bytes"`
+	MaxTextLength   int64  `yaml:"max_text_length"`
+	DefaultLexicons string `yaml:"default_lexicons"`
+	ValidateVoices  bool   `yaml:"validate_voices"`
+
+	APIKeys           string `yaml:"api_keys"`
+	TenantNamespaces  bool   `yaml:"tenant_namespaces"`
+	AdminKey          string `yaml:"admin_key"`
+	SigningSecret     string `yaml:"signing_secret"`
+	RateLimitRequests int64  `yaml:"rate_limit_requests"`
+	RateLimitChars    int64  `yaml:"rate_limit_chars"`
+	MonthlyCharQuota  int64  `yaml:"monthly_char_quota"`
+
+	WebhookSecret         string        `yaml:"webhook_secret"`
+	PublicURL             string        `yaml:"public_url"`
+	JobConcurrency        int64         `yaml:"job_concurrency"`
+	WarmConcurrency       int64         `yaml:"warm_concurrency"`
+	BatchSynthesisChars   int64         `yaml:"batch_synthesis_chars"`
+	BatchSynthesisTimeout time.Duration `yaml:"batch_synthesis_timeout"`
+	SplitMaxChars         int64         `yaml:"split_max_chars"`
+
+	TranslatorKey      string `yaml:"translator_key"`
+	TranslatorRegion   string `yaml:"translator_region"`
+	TranslatorEndpoint string `yaml:"translator_endpoint"`
+
+	TTSProvider         string `yaml:"tts_provider"`
+	GoogleTTSAPIKey     string `yaml:"google_tts_api_key"`
+	OpenAIAPIKey        string `yaml:"openai_api_key"`
+	OpenAITTSModel      string `yaml:"openai_tts_model"`
+	LocalTTSCommand     string `yaml:"local_tts_command"`
+	LocalTTSContentType string `yaml:"local_tts_content_type"`
+	FFmpegPath          string `yaml:"ffmpeg_path"`
+	LoudnessTarget      int64  `yaml:"loudness_target"`
+}
+
+// DefaultConfig returns the settings used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Port:                    "8080",
+		PersistCache:            true,
+		Compression:             "zstd",
+		SaveInterval:            30 * time.Second,
+		CacheWAL:                true,
+		SnapshotEvery:           time.Hour,
+		TempCacheTTL:            5 * time.Minute,
+		S3Endpoint:              "https://s3.amazonaws.com",
+		BackupInterval:          24 * time.Hour,
+		BackupRetention:         7,
+		HotCacheBytes:           64 << 20,
+		AzureKeyVaultRefresh:    time.Hour,
+		AzureTimeout:            30 * time.Second,
+		AzureRetries:            2,
+		AzureRetryDelay:         500 * time.Millisecond,
+		AllowClientCredentials:  true,
+		CircuitBreakerThreshold: 5,
+		CircuitBreakerCooldown:  30 * time.Second,
+		FailureCacheTTL:         time.Minute,
+		ValidateVoices:          true,
+		JobConcurrency:          4,
+		WarmConcurrency:         4,
+		BatchSynthesisTimeout:   time.Hour,
+		SplitMaxChars:           1000,
+		MaxBodyBytes:            1 << 20,
+		CORSMethods:             "GET, HEAD, POST, DELETE",
+		CORSHeaders:             "Authorization, Content-Type, X-Api-Key, X-Request-Id",
+	}
+}
+
+// ConfigFromEnv reads the settings from the environment, unset variables
+// keep their default.
+func ConfigFromEnv() (Config, error) {
+	return LoadConfig("")
+}
+
+// LoadConfig reads the settings from the yaml file at path, if set, and
+// overrides them with the ones set in the environment.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		if err := readConfigFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := readConfigEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readConfigFile decodes the settings one by one, so errors name the setting.
+func readConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: expected a mapping of settings", path)
+	}
+
+	fields := map[string]reflect.Value{}
+	value := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		fields[value.Type().Field(i).Tag.Get("yaml")] = value.Field(i)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, node := root.Content[i], root.Content[i+1]
+		field, ok := fields[key.Value]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown setting %s", path, key.Line, key.Value)
+		}
+		if err := node.Decode(field.Addr().Interface()); err != nil {
+			return fmt.Errorf("%s:%d: invalid %s: expected %s", path, node.Line, key.Value, settingType(field.Type()))
+		}
+	}
+	return nil
+}
+
+func settingType(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "a duration like 30s"
+	case t.Kind() == reflect.Bool:
+		return "true or false"
+	case t.Kind() == reflect.Int64:
+		return "a number"
+	}
+	return "a string"
+}
+
+// validate checks the settings that don't need parsing, the others are
+// checked when they're applied.
+func (cfg Config) validate() error {
+	for name, port := range map[string]string{"port": cfg.Port, "grpc_port": cfg.GRPCPort} {
+		if n, err := strconv.Atoi(port); port != "" && (err != nil || n < 0 || n > 65535) {
+			return fmt.Errorf("invalid %s: %q is not a port number", name, port)
+		}
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("invalid tls_key: tls_cert and tls_key have to be set together")
+	}
+	if cfg.TLSCert != "" && cfg.TLSAutocertDomains != "" {
+		return errors.New("invalid tls_autocert_domains: can't be used together with tls_cert")
+	}
+	switch cfg.CacheBackend {
+	case "", "memory", "disk", "redis", "bolt", "azureblob", "s3":
+	default:
+		return fmt.Errorf("invalid cache_backend: unknown backend %q", cfg.CacheBackend)
+	}
+	if _, ok := cacheCompressions[cfg.Compression]; cfg.Compression != "" && !ok {
+		return fmt.Errorf("invalid cache_compression: unknown compression %q", cfg.Compression)
+	}
+	if _, ok := azure.Clouds[cfg.AzureCloud]; cfg.AzureCloud != "" && !ok {
+		return fmt.Errorf("invalid azure_cloud: unknown cloud %q", cfg.AzureCloud)
+	}
+	switch cfg.AzureAuth {
+	case "", "key", "token":
+	case "aad":
+		if cfg.AzureSpeechResourceID == "" {
+			return errors.New("invalid azure_speech_resource_id: required for azure_auth aad")
+		}
+	default:
+		return fmt.Errorf("invalid azure_auth: unknown auth %q", cfg.AzureAuth)
+	}
+	switch cfg.CacheBackend {
+	case "bolt", "redis", "azureblob", "s3":
+		// the lru only wraps the backends that keep the entries in memory
+		if cfg.MaxCacheBytes > 0 {
+			return fmt.Errorf("invalid max_cache_bytes: not supported by the %s cache backend", cfg.CacheBackend)
+		}
+		if cfg.MaxCacheItems > 0 {
+			return fmt.Errorf("invalid max_cache_items: not supported by the %s cache backend", cfg.CacheBackend)
+		}
+	}
+	if cfg.CacheBackend == "redis" && cfg.RedisURL == "" {
+		return errors.New("invalid redis_url: required for the redis cache backend")
+	}
+	if cfg.CacheBackend == "azureblob" && cfg.StorageURL == "" {
+		return errors.New("invalid azure_storage_url: required for the azureblob cache backend")
+	}
+	if cfg.CacheBackend == "s3" && cfg.S3Bucket == "" {
+		return errors.New("invalid s3_bucket: required for the s3 cache backend")
+	}
+	if cfg.CacheEncryptionKey != "" {
+		if cfg.CacheEncryptionKeySecret != "" {
+			return errors.New("invalid cache_encryption_key: can't be used together with cache_encryption_key_secret")
+		}
+		if _, err := decodeEncryptionKey(cfg.CacheEncryptionKey); err != nil {
+			return err
+		}
+	}
+	if cfg.CacheEncryptionKeySecret != "" && cfg.AzureKeyVaultURL == "" {
+		return errors.New("invalid cache_encryption_key_secret: requires azure_key_vault_url")
+	}
+	if cfg.TenantNamespaces && cfg.APIKeys == "" {
+		return errors.New("invalid tenant_namespaces: requires api_keys")
+	}
+	if (cfg.S3AccessKey == "") != (cfg.S3SecretKey == "") {
+		return errors.New("invalid s3_secret_key: s3_access_key and s3_secret_key have to be set together")
+	}
+	for name, value := range map[string]int64{
+		"max_cache_bytes":          cfg.MaxCacheBytes,
+		"max_cache_items":          cfg.MaxCacheItems,
+		"hot_cache_bytes":          cfg.HotCacheBytes,
+		"hot_cache_items":          cfg.HotCacheItems,
+		"backup_retention":         cfg.BackupRetention,
+		"azure_retries":            cfg.AzureRetries,
+		"rate_limit_requests":      cfg.RateLimitRequests,
+		"rate_limit_chars":         cfg.RateLimitChars,
+		"monthly_char_quota":       cfg.MonthlyCharQuota,
+		"max_concurrent_synthesis": cfg.MaxConcurrentSynthesis,
+		"max_text_length":          cfg.MaxTextLength,
+	} {
+		if value < 0 {
+			return fmt.Errorf("invalid %s: can't be negative", name)
+		}
+	}
+	for name, value := range map[string]int64{
+		"job_concurrency":  cfg.JobConcurrency,
+		"warm_concurrency": cfg.WarmConcurrency,
+		"split_max_chars":  cfg.SplitMaxChars,
+		"max_body_bytes":   cfg.MaxBodyBytes,
+	} {
+		if value < 1 {
+			return fmt.Errorf("invalid %s: has to be at least 1", name)
+		}
+	}
+	if cfg.SaveInterval <= 0 {
+		return errors.New("invalid save_interval: has to be positive")
+	}
+	if cfg.SnapshotEvery <= 0 {
+		return errors.New("invalid snapshot_interval: has to be positive")
+	}
+	if cfg.BackupInterval < 0 {
+		return errors.New("invalid backup_interval: can't be negative")
+	}
+	if cfg.TempCacheTTL <= 0 {
+		return errors.New("invalid temp_cache_ttl: has to be positive")
+	}
+	if cfg.LoudnessTarget != 0 {
+		if cfg.LoudnessTarget < -70 || cfg.LoudnessTarget > -5 {
+			return errors.New("invalid loudness_target: has to be between -70 and -5 LUFS")
+		}
+		if cfg.FFmpegPath == "" {
+			return errors.New("invalid loudness_target: requires ffmpeg_path")
+		}
+	}
+	if cfg.CacheTTL < 0 || cfg.MaxCacheTTL < 0 {
+		return errors.New("invalid cache_ttl or max_cache_ttl: can't be negative")
+	}
+	return nil
+}
+
+func readConfigEnv(cfg *Config) error {
+	env := envReader{}
+
+	env.str("PORT", &cfg.Port)
+	env.str("GRPC_PORT", &cfg.GRPCPort)
+	env.str("SOCKET_PATH", &cfg.SocketPath)
+	env.str("TLS_CERT", &cfg.TLSCert)
+	env.str("TLS_KEY", &cfg.TLSKey)
+	env.str("TLS_AUTOCERT_DOMAINS", &cfg.TLSAutocertDomains)
+	env.str("TLS_AUTOCERT_EMAIL", &cfg.TLSAutocertEmail)
+
+	env.str("CACHE_BACKEND", &cfg.CacheBackend)
+	env.str("CACHE_DIR", &cfg.CacheDir)
+	env.str("CACHE_FILE", &cfg.CacheFile)
+	env.str("BLOB_DIR", &cfg.BlobDir)
+	env.str("BOLT_FILE", &cfg.BoltFile)
+	env.str("REDIS_URL", &cfg.RedisURL)
+	env.str("AZURE_STORAGE_URL", &cfg.StorageURL)
+	env.str("S3_ENDPOINT", &cfg.S3Endpoint)
+	env.str("S3_REGION", &cfg.S3Region)
+	env.str("S3_BUCKET", &cfg.S3Bucket)
+	env.str("S3_PREFIX", &cfg.S3Prefix)
+	env.str("S3_ACCESS_KEY", &cfg.S3AccessKey)
+	env.str("S3_SECRET_KEY", &cfg.S3SecretKey)
+	env.str("BACKUP_URL", &cfg.BackupURL)
+	env.duration("BACKUP_INTERVAL", &cfg.BackupInterval)
+	env.integer("BACKUP_RETENTION", &cfg.BackupRetention)
+	env.boolean("PERSIST_CACHE", &cfg.PersistCache)
+	env.str("CACHE_COMPRESSION", &cfg.Compression)
+	env.duration("SAVE_INTERVAL", &cfg.SaveInterval)
+	env.boolean("CACHE_WAL", &cfg.CacheWAL)
+	env.duration("SNAPSHOT_INTERVAL", &cfg.SnapshotEvery)
+	env.integer("MAX_CACHE_BYTES", &cfg.MaxCacheBytes)
+	env.integer("MAX_CACHE_ITEMS", &cfg.MaxCacheItems)
+	env.duration("CACHE_TTL", &cfg.CacheTTL)
+	env.duration("MAX_CACHE_TTL", &cfg.MaxCacheTTL)
+	env.duration("TEMP_CACHE_TTL", &cfg.TempCacheTTL)
+	env.boolean("SLIDING_TTL", &cfg.SlidingTTL)
+	env.integer("HOT_CACHE_BYTES", &cfg.HotCacheBytes)
+	env.integer("HOT_CACHE_ITEMS", &cfg.HotCacheItems)
+	env.str("CACHE_ENCRYPTION_KEY", &cfg.CacheEncryptionKey)
+	env.str("CACHE_ENCRYPTION_KEY_SECRET", &cfg.CacheEncryptionKeySecret)
+
+	env.str("AZURE_KEY", &cfg.AzureKey)
+	env.str("AZURE_REGION", &cfg.AzureRegion)
+	env.str("AZURE_AUTH", &cfg.AzureAuth)
+	env.str("AZURE_SPEECH_RESOURCE_ID", &cfg.AzureSpeechResourceID)
+	env.str("AZURE_CLOUD", &cfg.AzureCloud)
+	env.str("AZURE_TTS_ENDPOINT", &cfg.AzureTTSEndpoint)
+	env.str("AZURE_VOICE_ENDPOINT", &cfg.AzureVoiceEndpoint)
+	env.str("AZURE_STT_ENDPOINT", &cfg.AzureSTTEndpoint)
+	env.str("AZURE_API_ENDPOINT", &cfg.AzureAPIEndpoint)
+	env.str("AZURE_AUTHORITY_HOST", &cfg.AzureAuthorityHost)
+	env.str("AZURE_KEY_VAULT_URL", &cfg.AzureKeyVaultURL)
+	env.str("AZURE_KEY_VAULT_SECRET", &cfg.AzureKeyVaultSecret)
+	env.duration("AZURE_KEY_VAULT_REFRESH", &cfg.AzureKeyVaultRefresh)
+	env.duration("AZURE_TIMEOUT", &cfg.AzureTimeout)
+	env.integer("AZURE_RETRIES", &cfg.AzureRetries)
+	env.duration("AZURE_RETRY_DELAY", &cfg.AzureRetryDelay)
+	env.str("AZURE_FAILOVER", &cfg.AzureFailover)
+	env.str("TENANT_AZURE_CREDENTIALS", &cfg.TenantAzureCredentials)
+	env.boolean("ALLOW_CLIENT_CREDENTIALS", &cfg.AllowClientCredentials)
+
+	env.integer("CIRCUIT_BREAKER_THRESHOLD", &cfg.CircuitBreakerThreshold)
+	env.duration("CIRCUIT_BREAKER_COOLDOWN", &cfg.CircuitBreakerCooldown)
+	env.integer("MAX_CONCURRENT_SYNTHESIS", &cfg.MaxConcurrentSynthesis)
+	env.duration("SYNTHESIS_QUEUE_TIMEOUT", &cfg.SynthesisQueueTimeout)
+	env.duration("FAILURE_CACHE_TTL", &cfg.FailureCacheTTL)
+	env.duration("CACHE_SOFT_TTL", &cfg.CacheSoftTTL)
+	env.boolean("READY_CHECK_AZURE", &cfg.ReadyCheckAzure)
+
+	env.str("HTTP_CACHE_CONTROL", &cfg.HTTPCacheControl)
+	env.str("CORS_ORIGINS", &cfg.CORSOrigins)
+	env.str("CORS_METHODS", &cfg.CORSMethods)
+	env.str("CORS_HEADERS", &cfg.CORSHeaders)
+
+	env.boolean("ALLOW_MARKUP", &cfg.AllowMarkup)
+	env.integer("MAX_BODY_BYTES", &cfg.MaxBodyBytes)
+	env.integer("MAX_TEXT_LENGTH", &cfg.MaxTextLength)
+	env.str("DEFAULT_LEXICONS", &cfg.DefaultLexicons)
+	env.boolean("VALIDATE_VOICES", &cfg.ValidateVoices)
+
+	env.str("API_KEYS", &cfg.APIKeys)
+	env.boolean("TENANT_NAMESPACES", &cfg.TenantNamespaces)
+	env.str("ADMIN_KEY", &cfg.AdminKey)
+	env.str("SIGNING_SECRET", &cfg.SigningSecret)
+	env.integer("RATE_LIMIT_REQUESTS", &cfg.RateLimitRequests)
+	env.integer("RATE_LIMIT_CHARS", &cfg.RateLimitChars)
+	env.integer("MONTHLY_CHAR_QUOTA", &cfg.MonthlyCharQuota)
+
+	env.str("WEBHOOK_SECRET", &cfg.WebhookSecret)
+	env.str("PUBLIC_URL", &cfg.PublicURL)
+	env.integer("JOB_CONCURRENCY", &cfg.JobConcurrency)
+	env.integer("WARM_CONCURRENCY", &cfg.WarmConcurrency)
+	env.integer("BATCH_SYNTHESIS_CHARS", &cfg.BatchSynthesisChars)
+	env.duration("BATCH_SYNTHESIS_TIMEOUT", &cfg.BatchSynthesisTimeout)
+	env.integer("SPLIT_MAX_CHARS", &cfg.SplitMaxChars)
+
+	env.str("TRANSLATOR_KEY", &cfg.TranslatorKey)
+	env.str("TRANSLATOR_REGION", &cfg.TranslatorRegion)
+	env.str("TRANSLATOR_ENDPOINT", &cfg.TranslatorEndpoint)
+
+	env.str("TTS_PROVIDER", &cfg.TTSProvider)
+	env.str("GOOGLE_TTS_API_KEY", &cfg.GoogleTTSAPIKey)
+	env.str("OPENAI_API_KEY", &cfg.OpenAIAPIKey)
+	env.str("OPENAI_TTS_MODEL", &cfg.OpenAITTSModel)
+	env.str("LOCAL_TTS_COMMAND", &cfg.LocalTTSCommand)
+	env.str("LOCAL_TTS_CONTENT_TYPE", &cfg.LocalTTSContentType)
+	env.str("FFMPEG_PATH", &cfg.FFmpegPath)
+	env.integer("LOUDNESS_TARGET", &cfg.LoudnessTarget)
+
+	return env.err
+}
+
+// envReader overrides the settings that are set in the environment and
+// keeps the first parse error.
+type envReader struct {
+	err error
+}
+
+func (e *envReader) str(name string, value *string) {
+	if v := os.Getenv(name); v != "" {
+		*value = v
+	}
+}
+
+// boolean only treats "true" and "false" as values, like the variables always did
+func (e *envReader) boolean(name string, value *bool) {
+	switch os.Getenv(name) {
+	case "true":
+		*value = true
+	case "false":
+		*value = false
+	}
+}
+
+func (e *envReader) integer(name string, value *int64) {
+	v := os.Getenv(name)
+	if v == "" || e.err != nil {
+		return
+	}
+	parsed, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		e.err = fmt.Errorf("invalid %s: %w", name, err)
+		return
+	}
+	*value = parsed
+}
+
+func (e *envReader) duration(name string, value *time.Duration) {
+	v := os.Getenv(name)
+	if v == "" || e.err != nil {
+		return
+	}
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		e.err = fmt.Errorf("invalid %s: %w", name, err)
+		return
+	}
+	*value = parsed
+}
+
+// applyConfig validates the settings and applies them.
+func applyConfig(cfg Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	err := azure.Setup(azure.Config{
+		Cloud:              cfg.AzureCloud,
+		TTSEndpoint:        cfg.AzureTTSEndpoint,
+		VoiceEndpoint:      cfg.AzureVoiceEndpoint,
+		STTEndpoint:        cfg.AzureSTTEndpoint,
+		APIEndpoint:        cfg.AzureAPIEndpoint,
+		TranslatorEndpoint: cfg.TranslatorEndpoint,
+		AuthorityHost:      cfg.AzureAuthorityHost,
+		Auth:               cfg.AzureAuth,
+		SpeechResourceID:   cfg.AzureSpeechResourceID,
+		Timeout:            cfg.AzureTimeout,
+		BreakerThreshold:   int(cfg.CircuitBreakerThreshold),
+		BreakerCooldown:    cfg.CircuitBreakerCooldown,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid azure settings: %w", err)
+	}
+
+	backend = cfg.CacheBackend
+	cacheDir = cfg.CacheDir
+	cacheFile = cfg.CacheFile
+	blobDir = cfg.BlobDir
+	boltFile = cfg.BoltFile
+	redisURL = cfg.RedisURL
+	storageURL = cfg.StorageURL
+	s3Endpoint = cfg.S3Endpoint
+	s3Region = cfg.S3Region
+	s3Bucket = cfg.S3Bucket
+	s3Prefix = cfg.S3Prefix
+	s3AccessKey = cfg.S3AccessKey
+	s3SecretKey = cfg.S3SecretKey
+	backupURL = cfg.BackupURL
+	backupInterval = cfg.BackupInterval
+	backupRetention = int(cfg.BackupRetention)
+	persist = cfg.PersistCache && !persistentBackend()
+	saveInterval = cfg.SaveInterval
+	walEnabled = cfg.CacheWAL
+	snapshotInterval = cfg.SnapshotEvery
+	cacheCompression = cfg.Compression
+	maxCacheBytes = cfg.MaxCacheBytes
+	maxCacheItems = cfg.MaxCacheItems
+	hotCacheBytes = cfg.HotCacheBytes
+	hotCacheItems = cfg.HotCacheItems
+	encryptionKey = cfg.CacheEncryptionKey
+	encryptionKeySecret = cfg.CacheEncryptionKeySecret
+	cacheTTL = cfg.CacheTTL
+	maxCacheTTL = cfg.MaxCacheTTL
+	tempCacheTTL = cfg.TempCacheTTL
+	slidingExpiration = cfg.SlidingTTL
+
+	azureKey = cfg.AzureKey
+	azureRegion = cfg.AzureRegion
+	azureAuth = cfg.AzureAuth
+	keyVaultURL = cfg.AzureKeyVaultURL
+	keyVaultSecret = cfg.AzureKeyVaultSecret
+	keyVaultRefresh = cfg.AzureKeyVaultRefresh
+	azureRetries = int(cfg.AzureRetries)
+	azureRetryDelay = cfg.AzureRetryDelay
+	allowClientCredentials = cfg.AllowClientCredentials
+	failoverTargets, err = parseFailoverTargets(cfg.AzureFailover)
+	if err != nil {
+		return fmt.Errorf("invalid azure_failover: %w", err)
+	}
+
+	readyCheckAzure = cfg.ReadyCheckAzure
+	synthesisSlots = nil
+	if cfg.MaxConcurrentSynthesis > 0 {
+		synthesisSlots = make(chan struct{}, cfg.MaxConcurrentSynthesis)
+	}
+	synthesisQueueTimeout = cfg.SynthesisQueueTimeout
+	failureTTL = cfg.FailureCacheTTL
+	softTTL = cfg.CacheSoftTTL
+	httpCacheControl = cfg.HTTPCacheControl
+	httpCacheMaxAge = parseCacheControl(cfg.HTTPCacheControl)
+	corsOrigins = parseList(cfg.CORSOrigins)
+	corsMethods = strings.Join(parseList(cfg.CORSMethods), ", ")
+	corsHeaders = strings.Join(parseList(cfg.CORSHeaders), ", ")
+
+	allowMarkup = cfg.AllowMarkup
+	maxBodyBytes = cfg.MaxBodyBytes
+	maxTextLength = int(cfg.MaxTextLength)
+	validateVoices = cfg.ValidateVoices
+	defaultLexicons, err = parseLexicons(cfg.DefaultLexicons)
+	if err != nil {
+		return fmt.Errorf("invalid default_lexicons: %w", err)
+	}
+
+	apiKeys, err = parseAPIKeys(cfg.APIKeys)
+	if err != nil {
+		return fmt.Errorf("invalid api_keys: %w", err)
+	}
+	tenantNamespaces = cfg.TenantNamespaces
+	tenantCredentials, err = parseTenantCredentials(cfg.TenantAzureCredentials, apiKeys)
+	if err != nil {
+		return fmt.Errorf("invalid tenant_azure_credentials: %w", err)
+	}
+	adminKey = cfg.AdminKey
+	signingSecret = cfg.SigningSecret
+	requestLimiter = newRateLimiter(float64(cfg.RateLimitRequests))
+	charLimiter = newRateLimiter(float64(cfg.RateLimitChars))
+	monthlyCharQuota = cfg.MonthlyCharQuota
+
+	webhookSecret = cfg.WebhookSecret
+	publicURL = cfg.PublicURL
+	jobConcurrency = int(cfg.JobConcurrency)
+	jobSlots = make(chan struct{}, max(jobConcurrency, 1))
+	warmConcurrency = int(cfg.WarmConcurrency)
+	batchThreshold = cfg.BatchSynthesisChars
+	batchTimeout = cfg.BatchSynthesisTimeout
+	splitMaxChars = int(cfg.SplitMaxChars)
+
+	translatorKey = cfg.TranslatorKey
+	translatorRegion = cfg.TranslatorRegion
+
+	defaultProvider = cfg.TTSProvider
+	googleAPIKey = cfg.GoogleTTSAPIKey
+	openaiAPIKey = cfg.OpenAIAPIKey
+	openaiModel = cfg.OpenAITTSModel
+	localCommand = strings.Fields(cfg.LocalTTSCommand)
+	localContentType = cfg.LocalTTSContentType
+	ffmpegPath = cfg.FFmpegPath
+	loudnessTarget = cfg.LoudnessTarget
+	if err := setupProviders(); err != nil {
+		return fmt.Errorf("invalid tts_provider: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsOrigins are the origins allowed to call the api from a browser,
+// "*" allows all of them. CORS is disabled when it's empty.
+var corsOrigins []string
+var corsMethods string
+var corsHeaders string
+
+// corsExposedHeaders are the response headers browsers let scripts read
+var corsExposedHeaders = strings.Join([]string{
+	"Age", "Content-Range", "ETag", "Retry-After", "X-Cache", "X-Cache-Date",
+	"X-Cache-Key", "X-Request-Id", "X-Translation", "X-TTS-Fallback",
+}, ", ")
+
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func allowedOrigin(origin string) bool {
+	return slices.Contains(corsOrigins, "*") || slices.Contains(corsOrigins, origin)
+}
+
+// handleCORS adds the CORS headers for allowed origins and responds
+// to preflight requests before they reach the api key checks.
+func handleCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(corsOrigins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !allowedOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Segment is a part of a dialogue spoken by its own voice.
+type Segment struct {
+	Text     string `json:"text"`
+	Name     string `json:"name"`
+	Style    string `json:"style"`
+	Language string `json:"language"`
+}
+
+// segmentRequest returns the request for a single segment, falling back to
+// the voice of the whole request.
+func segmentRequest(r TTSRequest, segment Segment) TTSRequest {
+	r.Segments = nil
+	r.Text = segment.Text
+	if segment.Name != "" {
+		r.Name = segment.Name
+	}
+	if segment.Style != "" {
+		r.Style = segment.Style
+	}
+	if segment.Language != "" {
+		r.Language = segment.Language
+	}
+	return r
+}
+
+// buildDialogueSSML builds a single document with a voice element per segment.
+func buildDialogueSSML(r TTSRequest, lexicon, prosody string) string {
+	var voices strings.Builder
+	if r.LeadingPause > 0 {
+		fmt.Fprintf(&voices, "\n        <voice name='%s'>%s</voice>", escapeXML(r.Name), breakTag(r.LeadingPause))
+	}
+	for _, segment := range r.Segments {
+		s := segmentRequest(r, segment)
+		text := escapeXML(s.Text)
+		if len(s.Phonemes) > 0 {
+			text = applyPhonemes(s.Text, s.Phonemes, s.PhonemeAlphabet)
+		}
+		text = addPauses(text, 0, 0)
+		fmt.Fprintf(&voices, `
+        <voice xml:lang='%s' name='%s' style='%s'>%s
+          <prosody %s>
+            %s
+          </prosody>
+        </voice>`, escapeXML(s.Language), escapeXML(s.Name), escapeXML(s.Style), lexicon, prosody, text)
+	}
+
+	if r.TrailingPause > 0 {
+		fmt.Fprintf(&voices, "\n        <voice name='%s'>%s</voice>", escapeXML(r.Name), breakTag(r.TrailingPause))
+	}
+
+	return fmt.Sprintf(`
+      <speak version='1.0' xml:lang='en-US'>%s
+      </speak>
+	`, voices.String())
+}
+
+// canonicalSegments returns the segments in a stable form for the cache key.
+func canonicalSegments(segments []Segment) string {
+	if len(segments) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(segments)
+	return string(data)
+}
+
+// requestText returns the text of the request as stored with the cache entry.
+func requestText(r TTSRequest) string {
+	if len(r.Segments) > 0 {
+		var texts []string
+		for _, segment := range r.Segments {
+			texts = append(texts, segment.Text)
+		}
+		return strings.Join(texts, "\n")
+	}
+	if r.Text == "" {
+		return r.SSML
+	}
+	return r.Text
+}
+
+// requestChars returns how many characters of the request count towards the limits.
+func requestChars(r TTSRequest) int64 {
+	// transcoding cached audio doesn't send anything to the provider
+	if transcoded(r) && !r.ForceRefresh {
+		if _, ok := lookupEntry(cacheKey(sourceRequest(r))); ok {
+			return 0
+		}
+	}
+	return int64(len([]rune(requestText(r))))
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// encryptionKey is the base64 encoded AES-256 key of the persisted cache,
+// or encryptionKeySecret the name of the key vault secret that has it
+var encryptionKey string
+var encryptionKeySecret string
+
+// setupEncryption enables the encryption of the cache, it has to be done
+// before the stores are opened.
+func setupEncryption() error {
+	key := encryptionKey
+	if encryptionKeySecret != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		secret, err := azure.KeyVaultSecret(ctx, keyVaultURL, encryptionKeySecret)
+		if err != nil {
+			return fmt.Errorf("failed to read encryption key from key vault: %w", err)
+		}
+		key = secret
+	}
+	if key == "" {
+		return cache.SetEncryptionKey(nil)
+	}
+
+	decoded, err := decodeEncryptionKey(key)
+	if err != nil {
+		return err
+	}
+	return cache.SetEncryptionKey(decoded)
+}
+
+func decodeEncryptionKey(key string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(decoded) != 32 {
+		return nil, errors.New("invalid cache_encryption_key: has to be 32 base64 encoded bytes")
+	}
+	return decoded, nil
+}
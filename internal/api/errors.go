@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiError is an error with a machine readable code and the request
+// field it is about, so clients don't have to parse the message.
+type apiError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// fieldError returns an error about the field of a request.
+func fieldError(code, field, format string, args ...any) error {
+	return &apiError{Code: code, Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// statusCodes are the codes of errors without a more specific one
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+// writeError responds with the error as json, like
+// {"error": {"code": "invalid_voice", "field": "name", "message": "..."}}.
+func writeError(w http.ResponseWriter, err error, status int) {
+	var body *apiError
+	if !errors.As(err, &body) {
+		code, ok := statusCodes[status]
+		if !ok {
+			code = "internal_error"
+		}
+		body = &apiError{Code: code, Message: err.Error()}
+	}
+
+	// same as http.Error, the headers may be set for the audio already
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": body})
+}
+
+// httpError is http.Error with a json body.
+func httpError(w http.ResponseWriter, message string, status int) {
+	writeError(w, errors.New(message), status)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// exportMeta is stored next to the audio of every entry in export archives
+type exportMeta struct {
+	Key       string    `json:"key"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text"`
+	Voice     string    `json:"voice"`
+	Language  string    `json:"language"`
+	Namespace string    `json:"namespace,omitempty"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires,omitzero"`
+	// TTLSeconds is the ttl the entry was stored with, for sliding expiration
+	TTLSeconds int64 `json:"ttlSeconds,omitempty"`
+}
+
+// writeExport writes the permanent cache as a gzipped tar archive with
+// a {key}.json metadata file followed by a {key}.audio file per entry.
+func writeExport(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for key, entry := range c.Items() {
+		meta, err := json.Marshal(exportMeta{
+			Key:        key,
+			Type:       entry.Type,
+			Text:       entry.Text,
+			Voice:      entry.Voice,
+			Language:   entry.Language,
+			Namespace:  entry.Namespace,
+			Created:    entry.Created,
+			Expires:    entry.Expires,
+			TTLSeconds: int64(entry.TTL.Seconds()),
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, key+".json", int64(len(meta)), strings.NewReader(string(meta))); err != nil {
+			return err
+		}
+
+		audio, err := openEntry(entry)
+		if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, key+".audio", cache.Size(key, entry)-int64(len(key)), audio)
+		audio.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// readImport adds the entries of an archive created by writeExport to the permanent cache.
+func readImport(r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(gz)
+
+	imported := 0
+	var meta *exportMeta
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, err
+		}
+
+		name := path.Base(header.Name)
+		switch path.Ext(name) {
+		case ".json":
+			meta = &exportMeta{}
+			if err := json.NewDecoder(tr).Decode(meta); err != nil {
+				return imported, fmt.Errorf("%s: %w", name, err)
+			}
+		case ".audio":
+			key := strings.TrimSuffix(name, ".audio")
+			if meta == nil || meta.Key != key || !isCacheKey(key) {
+				return imported, fmt.Errorf("%s: missing metadata", name)
+			}
+			audio, err := io.ReadAll(tr)
+			if err != nil {
+				return imported, err
+			}
+			entry := cache.Entry{
+				Audio:     audio,
+				Type:      meta.Type,
+				Text:      meta.Text,
+				Voice:     meta.Voice,
+				Language:  meta.Language,
+				Namespace: meta.Namespace,
+				Created:   meta.Created,
+				Expires:   meta.Expires,
+				TTL:       time.Duration(meta.TTLSeconds) * time.Second,
+			}
+			meta = nil
+			ttl, ok := remainingTTL(entry)
+			if !ok {
+				continue
+			}
+			c.Set(key, entry, ttl)
+			cacheSet(key, entry)
+			imported++
+		}
+	}
+
+	return imported, nil
+}
+
+func handleExportCache(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cache-%s.tar.gz"`, time.Now().Format("20060102-150405")))
+	if err := writeExport(w); err != nil {
+		// the archive is already partially written, abort the connection
+		// so the client doesn't mistake it for a complete export
+		slog.Error("Failed to export cache", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func handleImportCache(w http.ResponseWriter, r *http.Request) {
+	imported, err := readImport(r.Body)
+	if err != nil {
+		httpError(w, fmt.Sprintf("import failed after %d entries: %s", imported, err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": imported,
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// exportedFile is an entry of the manifest.json written by exportDir. The
+// entries don't keep the whole request, so importDir stores the audio under
+// Key and the other fields only describe it.
+type exportedFile struct {
+	Key       string `json:"key"`
+	File      string `json:"file"`
+	Type      string `json:"contentType"`
+	Text      string `json:"text"`
+	Voice     string `json:"voice,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// exportDir writes the audio of every entry of the permanent cache to dir as
+// {hash}.mp3, named after the sha-256 hash of the audio so the files never
+// change and entries with the same audio share a file, and a manifest.json
+// that maps the cache keys and texts to the files. It returns how many
+// entries were exported.
+func exportDir(dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	var files []exportedFile
+	for key, entry := range c.Items() {
+		// the object storage backends list the entries without the audio
+		entry, err := cache.Loaded(entry)
+		if err != nil {
+			return 0, err
+		}
+		name := cache.Hash(entry) + audioExtension(entry.Type)
+		if err := exportAudio(filepath.Join(dir, name), entry); err != nil {
+			return 0, err
+		}
+		files = append(files, exportedFile{
+			Key:       key,
+			File:      name,
+			Type:      entry.Type,
+			Text:      entry.Text,
+			Voice:     entry.Voice,
+			Language:  entry.Language,
+			Namespace: entry.Namespace,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Key < files[j].Key
+	})
+
+	// the manifest is written last, so it only lists files that exist
+	err := cache.WriteFileAtomic(filepath.Join(dir, manifestFile), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(append([]exportedFile{}, files...))
+	})
+	if err == nil {
+		err = os.Chmod(filepath.Join(dir, manifestFile), 0o644)
+	}
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("Exported cache to directory", "dir", dir, "entries", len(files))
+	return len(files), nil
+}
+
+func exportAudio(path string, entry cache.Entry) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	audio, err := openEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer audio.Close()
+	err = cache.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, audio)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// the files are shipped to other places, not private to the server
+	return os.Chmod(path, 0o644)
+}
+
+// audioExtension returns the file extension of the content type, the
+// reverse of audioTypes.
+func audioExtension(contentType string) string {
+	for extension, audioType := range audioTypes {
+		if audioType == contentType {
+			return extension
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for extension, audioType := range audioTypes {
+		if audioType == mediaType {
+			return extension
+		}
+	}
+	return ".audio"
+}
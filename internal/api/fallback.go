@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+// localCommand synthesizes the text from stdin to audio on stdout when the
+// provider is unavailable, e.g. `piper --model en_US-lessac-medium.onnx --output_file -`
+// or `espeak-ng --stdout`
+var localCommand []string
+var localContentType string
+
+// shouldFallback reports whether the error means the provider can't be used
+// right now: it couldn't be reached, failed or throttled the request.
+// Invalid requests and the limits of the proxy are returned to the client.
+func shouldFallback(err error) bool {
+	if len(localCommand) == 0 {
+		return false
+	}
+	if errors.Is(err, azure.ErrCircuitOpen) {
+		return true
+	}
+	var azureErr *azure.Error
+	if errors.As(err, &azureErr) {
+		return unavailableStatus(azureErr.StatusCode)
+	}
+	var providerErr *providerError
+	if errors.As(err, &providerErr) {
+		return unavailableStatus(providerErr.StatusCode)
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func unavailableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// serveFallback synthesizes the request with the local command. The audio
+// isn't cached so the next request tries the provider again.
+func serveFallback(w http.ResponseWriter, r *http.Request, ttsRequest TTSRequest, reason error) {
+	audio, contentType, err := synthesizeLocally(r.Context(), ttsRequest, reason)
+	if err != nil {
+		httpError(w, reason.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-TTS-Fallback", "local")
+	setCacheControl(w, false)
+	w.Write(audio)
+}
+
+// synthesizeLocally runs the local command for the request, it returns the
+// audio and its content type.
+func synthesizeLocally(ctx context.Context, ttsRequest TTSRequest, reason error) ([]byte, string, error) {
+	slog.Warn("Using local synthesis", "reason", reason)
+	requestInfoFrom(ctx).Cache = "fallback"
+
+	cmd := exec.CommandContext(ctx, localCommand[0], localCommand[1:]...)
+	cmd.Stdin = strings.NewReader(plainText(ttsRequest))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	audio, err := cmd.Output()
+	if err != nil {
+		slog.Error("Local synthesis failed", "error", err, "stderr", stderr.String())
+		return nil, "", err
+	}
+
+	contentType := localContentType
+	if contentType == "" {
+		contentType = "audio/wav"
+	}
+	return audio, contentType, nil
+}
+
+// plainText returns the text of the request without ssml markup.
+func plainText(ttsRequest TTSRequest) string {
+	if ttsRequest.SSML == "" && !ttsRequest.AllowMarkup {
+		return pauseMarker.ReplaceAllString(requestText(ttsRequest), " ")
+	}
+
+	doc := ttsRequest.SSML
+	if doc == "" {
+		doc = "<speak>" + ttsRequest.Text + "</speak>"
+	}
+	var text strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := token.(xml.CharData); ok {
+			text.Write(data)
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
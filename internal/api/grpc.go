@@ -0,0 +1,464 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const grpcChunkSize = 32 * 1024
+
+// speechFile describes the messages of tts.proto. The messages are handled
+// dynamically so the generated code doesn't have to be checked in, the
+// fields have to be kept in sync with tts.proto.
+var speechFile = buildSpeechFile()
+var synthesizeRequestDesc = speechFile.Messages().ByName("SynthesizeRequest")
+var audioChunkDesc = speechFile.Messages().ByName("AudioChunk")
+
+var speechCacheService = grpc.ServiceDesc{
+	ServiceName: "tts.v1.SpeechCache",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Synthesize",
+		Handler:       handleGRPCSynthesize,
+		ServerStreams: true,
+	}},
+	Metadata: "tts.proto",
+}
+
+func buildSpeechFile() protoreflect.FileDescriptor {
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   kind.Enum(),
+		}
+	}
+	repeated := func(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := field(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		f.TypeName = proto.String(typeName)
+		return f
+	}
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+	boolean := descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	int32Kind := descriptorpb.FieldDescriptorProto_TYPE_INT32
+
+	// ttl_seconds is optional so 0 can be told apart from not set
+	ttlSeconds := field("ttl_seconds", 27, int32Kind)
+	ttlSeconds.Proto3Optional = proto.Bool(true)
+	ttlSeconds.OneofIndex = proto.Int32(0)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("tts.proto"),
+		Package: proto.String("tts.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("SynthesizeRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("text", 1, str),
+					field("ssml", 2, str),
+					field("language", 3, str),
+					field("gender", 4, str),
+					field("name", 5, str),
+					field("style", 6, str),
+					field("rate", 7, str),
+					field("pitch", 8, str),
+					field("volume", 9, str),
+					field("should_cache", 10, boolean),
+					field("provider", 11, str),
+					field("namespace", 12, str),
+					field("format", 13, str),
+					field("style_degree", 14, str),
+					field("role", 15, str),
+					field("azure_key", 16, str),
+					field("azure_region", 17, str),
+					field("allow_markup", 18, boolean),
+					field("deployment_id", 19, str),
+					field("lexicon_url", 20, str),
+					repeated("phonemes", 21, ".tts.v1.SynthesizeRequest.PhonemesEntry"),
+					field("phoneme_alphabet", 22, str),
+					repeated("segments", 23, ".tts.v1.Segment"),
+					field("leading_pause_ms", 24, int32Kind),
+					field("trailing_pause_ms", 25, int32Kind),
+					field("force_refresh", 26, boolean),
+					ttlSeconds,
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("PhonemesEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, str),
+						field("value", 2, str),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{
+					Name: proto.String("_ttl_seconds"),
+				}},
+			},
+			{
+				Name: proto.String("Segment"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("text", 1, str),
+					field("name", 2, str),
+					field("style", 3, str),
+					field("language", 4, str),
+				},
+			},
+			{
+				Name: proto.String("AudioChunk"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("audio", 1, descriptorpb.FieldDescriptorProto_TYPE_BYTES),
+					field("content_type", 2, str),
+					field("cache_key", 3, str),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("SpeechCache"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:            proto.String("Synthesize"),
+				InputType:       proto.String(".tts.v1.SynthesizeRequest"),
+				OutputType:      proto.String(".tts.v1.AudioChunk"),
+				ServerStreaming: proto.Bool(true),
+			}},
+		}},
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		panic(err)
+	}
+	return fd
+}
+
+// GRPC returns a grpc server with the SpeechCache service, it's served
+// separately from the http api.
+func (s *Server) GRPC() *grpc.Server {
+	server := grpc.NewServer()
+	server.RegisterService(&speechCacheService, struct{}{})
+	return server
+}
+
+// handleGRPCSynthesize is the grpc version of /tts, it streams the audio
+// from the cache or from the provider in chunks.
+func handleGRPCSynthesize(_ interface{}, stream grpc.ServerStream) (err error) {
+	start := time.Now()
+	info := &requestInfo{ID: newRequestID()}
+	ctx := context.WithValue(stream.Context(), requestInfoKey{}, info)
+	defer func() {
+		attrs := []any{
+			"requestId", info.ID,
+			"method", "grpc",
+			"path", "/tts.v1.SpeechCache/Synthesize",
+			"code", status.Code(err).String(),
+			"latency", time.Since(start),
+		}
+		if info.Client != "" {
+			attrs = append(attrs, "client", info.Client)
+		}
+		if info.Cache != "" {
+			attrs = append(attrs, "cache", info.Cache)
+		}
+		if info.Voice != "" {
+			attrs = append(attrs, "voice", info.Voice)
+		}
+		if info.AzureLatency > 0 {
+			attrs = append(attrs, "azureLatency", info.AzureLatency)
+		}
+		slog.Info("request", attrs...)
+	}()
+
+	ctx, span := tracer.Start(ctx, "grpc.synthesize")
+	defer func() { endSpan(span, err) }()
+
+	if len(apiKeys) > 0 {
+		name, ok := lookupAPIKey(grpcAPIKey(ctx))
+		if !ok {
+			return status.Error(codes.Unauthenticated, "invalid or missing api key")
+		}
+		info.Client = name
+		ctx = withTenant(context.WithValue(ctx, clientKey{}, name), name)
+	}
+	if ok, retryAfter := requestLimiter.take(grpcLimitID(ctx), 1); !ok {
+		stream.SetTrailer(metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))))
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	msg := dynamicpb.NewMessage(synthesizeRequestDesc)
+	if err := stream.RecvMsg(msg); err != nil {
+		return err
+	}
+	ttsRequest := ttsRequestFromMessage(msg)
+	if err := prepareRequest(ctx, &ttsRequest); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	key := cacheKey(ttsRequest)
+	info.Voice = ttsRequest.Name
+	entry, temp, ok := cachedEntry(key)
+	info.Cache = "hit"
+	if temp {
+		info.Cache = "temp-hit"
+	}
+	if ok && !ttsRequest.ForceRefresh {
+		recordHit(key, entry, temp)
+		if info.Cache == "hit" && isStale(entry) {
+			revalidate(ctx, ttsRequest, key)
+		}
+		return sendEntry(stream, key, entry)
+	}
+	info.Cache = "miss"
+	counters.misses.Add(1)
+
+	chars := requestChars(ttsRequest)
+	if err := synthesisAllowed(ctx, grpcLimitID(ctx), ttsRequest, chars); err != nil {
+		var limitErr *limitError
+		if errors.As(err, &limitErr) && limitErr.code == "quota_exceeded" && len(localCommand) > 0 {
+			return sendFallback(ctx, stream, ttsRequest, err)
+		}
+		if errors.As(err, &limitErr) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	w := &grpcAudioWriter{stream: stream, key: key, header: http.Header{}}
+	streamed := false
+	val, err, _ := synthesisGroup.Do(key, func() (interface{}, error) {
+		streamed = true
+		return synthesize(ctx, w, ttsRequest, key)
+	})
+	if streamed && err == nil {
+		usage.add(clientName(ctx), chars)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if w.sent {
+			// the audio is already partially sent
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		if shouldFallback(err) {
+			return sendFallback(ctx, stream, ttsRequest, err)
+		}
+		if errors.Is(err, azure.ErrCircuitOpen) {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		if errors.Is(err, errSynthesisBusy) {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		var azureErr *azure.Error
+		if errors.As(err, &azureErr) {
+			switch azureErr.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden:
+				return status.Error(codes.Unauthenticated, err.Error())
+			case http.StatusTooManyRequests:
+				return status.Error(codes.ResourceExhausted, err.Error())
+			case http.StatusBadRequest:
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	if !streamed {
+		entry := val.(cache.Entry)
+		if ttsRequest.ShouldCache {
+			storeEntry(ttsRequest, key, entry)
+		}
+		return sendEntry(stream, key, entry)
+	}
+	return nil
+}
+
+func ttsRequestFromMessage(msg *dynamicpb.Message) TTSRequest {
+	fields := synthesizeRequestDesc.Fields()
+	get := func(name protoreflect.Name) protoreflect.Value {
+		return msg.Get(fields.ByName(name))
+	}
+	ttsRequest := TTSRequest{
+		Text:            get("text").String(),
+		SSML:            get("ssml").String(),
+		Language:        get("language").String(),
+		Gender:          get("gender").String(),
+		Name:            get("name").String(),
+		Style:           get("style").String(),
+		StyleDegree:     get("style_degree").String(),
+		Role:            get("role").String(),
+		Rate:            get("rate").String(),
+		Pitch:           get("pitch").String(),
+		Volume:          get("volume").String(),
+		AzureKey:        get("azure_key").String(),
+		AzureRegion:     get("azure_region").String(),
+		ShouldCache:     get("should_cache").Bool(),
+		AllowMarkup:     get("allow_markup").Bool(),
+		DeploymentID:    get("deployment_id").String(),
+		LexiconURL:      get("lexicon_url").String(),
+		PhonemeAlphabet: get("phoneme_alphabet").String(),
+		LeadingPause:    int(get("leading_pause_ms").Int()),
+		TrailingPause:   int(get("trailing_pause_ms").Int()),
+		Provider:        get("provider").String(),
+		ForceRefresh:    get("force_refresh").Bool(),
+		Namespace:       get("namespace").String(),
+		Format:          get("format").String(),
+	}
+	if phonemes := get("phonemes").Map(); phonemes.Len() > 0 {
+		ttsRequest.Phonemes = make(map[string]string, phonemes.Len())
+		phonemes.Range(func(key protoreflect.MapKey, value protoreflect.Value) bool {
+			ttsRequest.Phonemes[key.String()] = value.String()
+			return true
+		})
+	}
+	segments := get("segments").List()
+	for i := 0; i < segments.Len(); i++ {
+		segment := segments.Get(i).Message()
+		segmentFields := segment.Descriptor().Fields()
+		ttsRequest.Segments = append(ttsRequest.Segments, Segment{
+			Text:     segment.Get(segmentFields.ByName("text")).String(),
+			Name:     segment.Get(segmentFields.ByName("name")).String(),
+			Style:    segment.Get(segmentFields.ByName("style")).String(),
+			Language: segment.Get(segmentFields.ByName("language")).String(),
+		})
+	}
+	if ttl := fields.ByName("ttl_seconds"); msg.Has(ttl) {
+		ttlSeconds := int(msg.Get(ttl).Int())
+		ttsRequest.TTLSeconds = &ttlSeconds
+	}
+	return ttsRequest
+}
+
+func newAudioChunk(audio []byte, contentType, key string) *dynamicpb.Message {
+	fields := audioChunkDesc.Fields()
+	chunk := dynamicpb.NewMessage(audioChunkDesc)
+	chunk.Set(fields.ByName("audio"), protoreflect.ValueOfBytes(audio))
+	if contentType != "" {
+		chunk.Set(fields.ByName("content_type"), protoreflect.ValueOfString(contentType))
+	}
+	if key != "" {
+		chunk.Set(fields.ByName("cache_key"), protoreflect.ValueOfString(key))
+	}
+	return chunk
+}
+
+// sendEntry streams a cached entry, the first chunk carries the content
+// type and the cache key.
+func sendEntry(stream grpc.ServerStream, key string, entry cache.Entry) error {
+	audio, err := openEntry(entry)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer audio.Close()
+
+	contentType := entry.Type
+	buffer := make([]byte, grpcChunkSize)
+	for {
+		n, err := io.ReadFull(audio, buffer)
+		if n > 0 {
+			if err := stream.SendMsg(newAudioChunk(buffer[:n], contentType, key)); err != nil {
+				return err
+			}
+			contentType, key = "", ""
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// sendFallback streams the audio of the local command, it isn't cached so
+// the first chunk has no cache key.
+func sendFallback(ctx context.Context, stream grpc.ServerStream, ttsRequest TTSRequest, reason error) error {
+	audio, contentType, err := synthesizeLocally(ctx, ttsRequest, reason)
+	if err != nil {
+		return status.Error(codes.Unavailable, reason.Error())
+	}
+	stream.SetHeader(metadata.Pairs("x-tts-fallback", "local"))
+	return sendEntry(stream, "", cache.Entry{Audio: audio, Type: contentType})
+}
+
+// grpcAudioWriter lets synthesize stream the audio to a grpc client as it
+// would to an http one.
+type grpcAudioWriter struct {
+	stream grpc.ServerStream
+	key    string
+	header http.Header
+	sent   bool
+	err    error
+}
+
+func (w *grpcAudioWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcAudioWriter) WriteHeader(int) {}
+
+func (w *grpcAudioWriter) Write(b []byte) (int, error) {
+	// keep reading from the provider when the client goes away so the
+	// audio still ends up in the cache
+	if w.err != nil {
+		return len(b), nil
+	}
+	contentType, key := "", ""
+	if !w.sent {
+		contentType, key = w.header.Get("Content-Type"), w.key
+		w.sent = true
+	}
+	w.err = w.stream.SendMsg(newAudioChunk(b, contentType, key))
+	return len(b), nil
+}
+
+func grpcAPIKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get("x-api-key"); len(keys) > 0 && keys[0] != "" {
+		return keys[0]
+	}
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+func grpcLimitID(ctx context.Context) string {
+	if name := clientName(ctx); name != "" {
+		return name
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	protoMessage = regexp.MustCompile(`^message (\w+) \{$`)
+	protoField   = regexp.MustCompile(`^\s+(?:optional |repeated )?(?:map<[^>]+>|\w+) (\w+) = (\d+);$`)
+)
+
+// TestSpeechFileMatchesProto checks the descriptor built in grpc.go has the
+// fields of tts.proto.
+func TestSpeechFileMatchesProto(t *testing.T) {
+	data, err := os.ReadFile("../../tts.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]map[string]int{}
+	var message string
+	for _, line := range regexp.MustCompile(`\r?\n`).Split(string(data), -1) {
+		if match := protoMessage.FindStringSubmatch(line); match != nil {
+			message = match[1]
+			fields[message] = map[string]int{}
+		} else if match := protoField.FindStringSubmatch(line); match != nil && message != "" {
+			fields[message][match[1]], _ = strconv.Atoi(match[2])
+		} else if line == "}" {
+			message = ""
+		}
+	}
+
+	messages := speechFile.Messages()
+	if messages.Len() != len(fields) {
+		t.Errorf("descriptor has %d messages, tts.proto has %d", messages.Len(), len(fields))
+	}
+	for name, protoFields := range fields {
+		desc := messages.ByName(protoreflect.Name(name))
+		if desc == nil {
+			t.Errorf("message %s is missing from the descriptor", name)
+			continue
+		}
+		if desc.Fields().Len() != len(protoFields) {
+			t.Errorf("%s has %d fields, tts.proto has %d", name, desc.Fields().Len(), len(protoFields))
+		}
+		for field, number := range protoFields {
+			fd := desc.Fields().ByName(protoreflect.Name(field))
+			if fd == nil || int(fd.Number()) != number {
+				t.Errorf("%s.%s = %d is missing from the descriptor", name, field, number)
+			}
+		}
+	}
+}
+
+func TestTTSRequestFromMessage(t *testing.T) {
+	fields := synthesizeRequestDesc.Fields()
+	msg := dynamicpb.NewMessage(synthesizeRequestDesc)
+	msg.Set(fields.ByName("text"), protoreflect.ValueOfString("Hello"))
+	msg.Set(fields.ByName("leading_pause_ms"), protoreflect.ValueOfInt32(250))
+	msg.Set(fields.ByName("ttl_seconds"), protoreflect.ValueOfInt32(0))
+	msg.Mutable(fields.ByName("phonemes")).Map().Set(
+		protoreflect.ValueOfString("Azure").MapKey(), protoreflect.ValueOfString("ˈæʒər"))
+	segments := msg.Mutable(fields.ByName("segments")).List()
+	segment := segments.NewElement()
+	segment.Message().Set(segment.Message().Descriptor().Fields().ByName("text"), protoreflect.ValueOfString("Hi"))
+	segments.Append(segment)
+
+	// decode it again like a request off the wire
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := dynamicpb.NewMessage(synthesizeRequestDesc)
+	if err := proto.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	r := ttsRequestFromMessage(decoded)
+	if r.Text != "Hello" || r.LeadingPause != 250 {
+		t.Errorf("got text %q and leading pause %d", r.Text, r.LeadingPause)
+	}
+	if r.TTLSeconds == nil || *r.TTLSeconds != 0 {
+		t.Errorf("ttl_seconds 0 wasn't kept apart from unset: %v", r.TTLSeconds)
+	}
+	if r.Phonemes["Azure"] != "ˈæʒər" {
+		t.Errorf("got phonemes %v", r.Phonemes)
+	}
+	if len(r.Segments) != 1 || r.Segments[0].Text != "Hi" {
+		t.Errorf("got segments %v", r.Segments)
+	}
+
+	if r := ttsRequestFromMessage(dynamicpb.NewMessage(synthesizeRequestDesc)); r.TTLSeconds != nil {
+		t.Errorf("unset ttl_seconds read as %d", *r.TTLSeconds)
+	}
+}
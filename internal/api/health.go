@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+// ready is set once the cache is loaded
+var ready atomic.Bool
+
+var readyCheckAzure bool
+
+// the probe result is reused so frequent readiness checks don't hit azure every time
+const azureProbeInterval = 30 * time.Second
+
+var azureProbeMu sync.Mutex
+var azureProbeChecked time.Time
+var azureProbeErr error
+
+// handleHealthz reports that the process is alive.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// handleReadyz reports whether the server can take requests: the cache
+// is loaded and, when READY_CHECK_AZURE is set, azure is reachable.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		httpError(w, "cache is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	if readyCheckAzure {
+		if err := probeAzure(r.Context()); err != nil {
+			httpError(w, "azure is unreachable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Write([]byte("ok"))
+}
+
+// probeAzure lists the voices of the default region to check the
+// connection and the credentials.
+func probeAzure(ctx context.Context) error {
+	azureProbeMu.Lock()
+	defer azureProbeMu.Unlock()
+	if time.Since(azureProbeChecked) < azureProbeInterval {
+		return azureProbeErr
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	azureProbeErr = requestVoiceList(ctx)
+	azureProbeChecked = time.Now()
+	return azureProbeErr
+}
+
+func requestVoiceList(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, azure.RegionEndpoint(azure.Cloud.TTS, azureRegion)+"/cognitiveservices/voices/list", nil)
+	if err != nil {
+		return err
+	}
+	if err := azure.SetAuth(ctx, req.Header, azureRegion, serverKey()); err != nil {
+		return err
+	}
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return azure.ResponseError(resp)
+	}
+	return nil
+}
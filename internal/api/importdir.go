@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// manifestFile lists the audio files of an import directory
+const manifestFile = "manifest.json"
+
+// audioTypes are the content types of the audio files in an import
+// directory without a content type
+var audioTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg; codecs=opus",
+	".webm": "audio/webm",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+}
+
+// manifestEntry is an audio file of an import directory, the other fields
+// are the /tts request it's served for.
+type manifestEntry struct {
+	TTSRequest
+	// File is the path of the audio relative to the directory
+	File string `json:"file"`
+	// ContentType is the content type of the audio, taken from the format
+	// of the request or the extension of the file when it's not set
+	ContentType string `json:"contentType"`
+	// Voice is the same as name
+	Voice string `json:"voice"`
+	// Key is the cache key, instead of the key of the request. It's set in
+	// the manifests written by exportDir, which don't have all the fields
+	// of the requests.
+	Key string `json:"key"`
+}
+
+// ImportResult is the outcome of importing a directory of audio files.
+type ImportResult struct {
+	Total    int      `json:"total"`
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// importDir adds the audio files listed in the manifest of dir to the
+// permanent cache, under the key of their /tts request. Files that are
+// already cached are skipped unless their entry has forceRefresh.
+func importDir(dir string) (ImportResult, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return ImportResult{}, err
+	}
+	var entries []manifestEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// a misspelled field would import the audio under the wrong key
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&entries); err != nil {
+		return ImportResult{}, fmt.Errorf("%s: %w", manifestFile, err)
+	}
+
+	result := ImportResult{Total: len(entries)}
+	for i, entry := range entries {
+		imported, err := importFile(dir, entry)
+		switch {
+		case err != nil:
+			result.Failed++
+			if len(result.Errors) < maxWarmErrors {
+				name := entry.File
+				if name == "" {
+					name = fmt.Sprintf("entry %d", i)
+				}
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", name, err))
+			}
+		case imported:
+			result.Imported++
+		default:
+			result.Skipped++
+		}
+	}
+	if result.Imported > 0 {
+		slog.Info("Imported audio files", "dir", dir, "imported", result.Imported)
+	}
+	return result, nil
+}
+
+// importFile stores the audio of the manifest entry, it returns false when
+// it was already cached.
+func importFile(dir string, entry manifestEntry) (bool, error) {
+	if entry.File == "" {
+		return false, errors.New("file is required")
+	}
+	if !filepath.IsLocal(entry.File) {
+		return false, errors.New("file has to be inside the directory")
+	}
+
+	ttsRequest := entry.TTSRequest
+	if ttsRequest.Name == "" {
+		ttsRequest.Name = entry.Voice
+	}
+	key := entry.Key
+	if key == "" {
+		if err := prepareRequest(context.Background(), &ttsRequest); err != nil {
+			return false, err
+		}
+		if ttsRequest.Split {
+			return false, errors.New("split requests are cached by sentence and can't be imported")
+		}
+		key = cacheKey(ttsRequest)
+	} else if !isCacheKey(key) {
+		return false, errors.New("key is not a cache key")
+	}
+
+	contentType := entry.ContentType
+	if format, ok := audioFormats[ttsRequest.Format]; ok && contentType == "" {
+		contentType = format.contentType
+	}
+	if contentType == "" {
+		contentType = audioTypes[strings.ToLower(filepath.Ext(entry.File))]
+	}
+	if contentType == "" {
+		return false, errors.New("contentType is required for files with this extension")
+	}
+
+	if _, ok := c.Get(key); ok && !ttsRequest.ForceRefresh {
+		return false, nil
+	}
+
+	audio, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(entry.File)))
+	if err != nil {
+		return false, err
+	}
+	if len(audio) == 0 {
+		return false, errors.New("file is empty")
+	}
+
+	ttsRequest.ShouldCache = true
+	storeEntry(ttsRequest, key, cache.Entry{
+		Audio:    audio,
+		Type:     contentType,
+		Text:     requestText(ttsRequest),
+		Voice:    ttsRequest.Name,
+		Language: ttsRequest.Language,
+		Created:  time.Now(),
+	})
+	return true, nil
+}
+
+type importDirRequest struct {
+	// Dir is a directory on the server with a manifest.json
+	Dir string `json:"dir"`
+}
+
+func handleImportDir(w http.ResponseWriter, r *http.Request) {
+	var request importDirRequest
+	if err := readJSON(w, r, &request); err != nil {
+		bodyError(w, err)
+		return
+	}
+	if request.Dir == "" {
+		writeError(w, fieldError("missing_field", "dir", "dir is required"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := importDir(request.Dir)
+	if err != nil {
+		httpError(w, "import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if persist && result.Imported > 0 {
+		saveCache()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
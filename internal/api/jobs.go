@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+var jobConcurrency = 4
+
+// jobs are kept for this long after they finished
+const jobRetention = time.Hour
+
+type job struct {
+	mu       sync.Mutex
+	id       string
+	client   string
+	key      string
+	callback string
+	baseURL  string
+	status   string
+	err      string
+	entry    cache.Entry
+	created  time.Time
+	finished time.Time
+}
+
+var jobsMu sync.Mutex
+var jobs = map[string]*job{}
+var jobSlots = make(chan struct{}, 4)
+
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var ttsRequest TTSRequest
+	if err := readJSON(w, r, &ttsRequest); err != nil {
+		bodyError(w, err)
+		return
+	}
+	if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	// a job is synthesized as a whole, its audio couldn't be served for a
+	// split /tts request
+	if ttsRequest.Split {
+		writeError(w, fieldError("conflicting_fields", "split", "split can't be used with /jobs"), http.StatusBadRequest)
+		return
+	}
+
+	if ttsRequest.CallbackURL != "" {
+		if err := validateCallbackURL(r.Context(), ttsRequest.CallbackURL); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	key := cacheKey(ttsRequest)
+	j := &job{
+		id:       newJobID(),
+		client:   clientName(r.Context()),
+		key:      key,
+		callback: ttsRequest.CallbackURL,
+		baseURL:  baseURL(r),
+		status:   "queued",
+		created:  time.Now(),
+	}
+
+	if entry, ok := lookupEntry(key); ok && !ttsRequest.ForceRefresh {
+		j.status = "done"
+		j.entry = entry
+		j.finished = time.Now()
+	} else {
+		chars := requestChars(ttsRequest)
+		if !checkSynthesisAllowed(w, r, ttsRequest, chars) {
+			return
+		}
+	}
+
+	jobsMu.Lock()
+	for id, old := range jobs {
+		if old.finishedBefore(time.Now().Add(-jobRetention)) {
+			delete(jobs, id)
+		}
+	}
+	jobs[j.id] = j
+	jobsMu.Unlock()
+
+	ctx := context.WithValue(context.WithoutCancel(r.Context()), requestInfoKey{}, &requestInfo{})
+	if j.status == "queued" {
+		go j.run(ctx, ttsRequest)
+	} else if j.callback != "" {
+		go j.notify(ctx)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+j.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.snapshot())
+}
+
+func (j *job) run(ctx context.Context, ttsRequest TTSRequest) {
+	jobSlots <- struct{}{}
+	defer func() { <-jobSlots }()
+
+	j.setStatus("running")
+	var entry cache.Entry
+	var err error
+	if useBatch(ttsRequest) {
+		entry, err = synthesizeBatch(ctx, ttsRequest, j.key)
+	} else {
+		entry, err = synthesizeInBackground(ctx, ttsRequest, j.key)
+	}
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	if err != nil {
+		j.status = "failed"
+		j.err = err.Error()
+	} else {
+		j.status = "done"
+		j.entry = entry
+	}
+	j.mu.Unlock()
+
+	if j.callback != "" {
+		j.notify(ctx)
+	}
+}
+
+// notify sends the job result to the callback url of the job.
+func (j *job) notify(ctx context.Context) {
+	payload := j.snapshot()
+	if audioURL, ok := payload["audioUrl"].(string); ok {
+		payload["audioUrl"] = j.baseURL + audioURL
+	}
+	sendWebhook(ctx, j.callback, payload)
+}
+
+func (j *job) setStatus(status string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+}
+
+func (j *job) finishedBefore(t time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finished.IsZero() && j.finished.Before(t)
+}
+
+func (j *job) snapshot() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	result := map[string]interface{}{
+		"id":      j.id,
+		"status":  j.status,
+		"key":     j.key,
+		"created": j.created,
+	}
+	if j.err != "" {
+		result["error"] = j.err
+	}
+	if !j.finished.IsZero() {
+		result["finished"] = j.finished
+	}
+	if j.status == "done" {
+		result["audioUrl"] = "/jobs/" + j.id + "/audio"
+	}
+	return result
+}
+
+// findJob returns the job if it exists and belongs to the client of the request.
+func findJob(r *http.Request) (*job, bool) {
+	jobsMu.Lock()
+	j, ok := jobs[r.PathValue("id")]
+	jobsMu.Unlock()
+	if !ok || j.client != clientName(r.Context()) {
+		return nil, false
+	}
+	return j, true
+}
+
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := findJob(r)
+	if !ok {
+		httpError(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j.snapshot())
+}
+
+func handleGetJobAudio(w http.ResponseWriter, r *http.Request) {
+	j, ok := findJob(r)
+	if !ok {
+		httpError(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	j.mu.Lock()
+	status, entry := j.status, j.entry
+	j.mu.Unlock()
+
+	switch status {
+	case "done":
+		serveEntry(w, r, entry)
+	case "failed":
+		httpError(w, "job failed", http.StatusConflict)
+	default:
+		w.Header().Set("Retry-After", "1")
+		httpError(w, "job is "+status, http.StatusAccepted)
+	}
+}
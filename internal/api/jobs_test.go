@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateJobRejectsSplit(t *testing.T) {
+	withServerCredentials(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"text": "Hello. Bye.", "split": true}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handleCreateJob(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "conflicting_fields") {
+		t.Errorf("got %d %s, want a conflicting_fields error", w.Code, w.Body)
+	}
+}
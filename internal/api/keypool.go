@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+// keyRejectedCooldown is how long a server key azure rejected is skipped,
+// e.g. after it was rotated in the azure portal
+const keyRejectedCooldown = 5 * time.Minute
+
+// keyThrottledCooldown is how long a throttled server key is skipped when
+// azure doesn't say how long to wait
+const keyThrottledCooldown = 10 * time.Second
+
+// keyStats counts the requests sent with a server key
+type keyStats struct {
+	Requests  int64      `json:"requests"`
+	Throttled int64      `json:"throttled"`
+	Rejected  int64      `json:"rejected"`
+	DownUntil *time.Time `json:"downUntil,omitempty"`
+}
+
+// keyPool rotates the requests with the server credentials across the
+// keys of AZURE_KEY, skipping the ones azure throttled or rejected recently.
+type keyPool struct {
+	mu    sync.Mutex
+	next  atomic.Uint64
+	stats map[string]*keyStats
+}
+
+var serverKeyPool = &keyPool{stats: make(map[string]*keyStats)}
+
+// serverKeys returns the azure keys of the server, it can change when
+// they're read from the key vault.
+func serverKeys() []string {
+	azureKeyMu.RLock()
+	defer azureKeyMu.RUnlock()
+	return parseList(azureKey)
+}
+
+// serverKey returns the next healthy azure key of the server, or the next
+// one in turn when all of them are down.
+func serverKey() string {
+	if key, ok := healthyServerKey(); ok {
+		return key
+	}
+	keys := serverKeys()
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[int(serverKeyPool.next.Add(1))%len(keys)]
+}
+
+// healthyServerKey returns the next server key in turn that azure didn't
+// throttle or reject recently.
+func healthyServerKey() (string, bool) {
+	keys := serverKeys()
+	if len(keys) == 1 {
+		return keys[0], true
+	}
+	start := int(serverKeyPool.next.Add(1))
+	for i := range keys {
+		if key := keys[(start+i)%len(keys)]; serverKeyPool.healthy(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// usesServerKey reports whether the request is sent with the server
+// credentials rather than the ones of the client or a tenant.
+func usesServerKey(key string) bool {
+	keys := serverKeys()
+	if len(keys) == 0 {
+		return key == ""
+	}
+	return slices.Contains(keys, key)
+}
+
+func (p *keyPool) healthy(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats, ok := p.stats[key]
+	return !ok || stats.DownUntil == nil || time.Now().After(*stats.DownUntil)
+}
+
+// record counts the outcome of a request sent with a server key, keys that
+// were throttled or rejected are skipped until their cooldown has passed.
+func (p *keyPool) record(key string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats, ok := p.stats[key]
+	if !ok {
+		stats = &keyStats{}
+		p.stats[key] = stats
+	}
+	stats.Requests++
+
+	azureErr, ok := err.(*azure.Error)
+	if !ok {
+		return
+	}
+	var cooldown time.Duration
+	switch azureErr.StatusCode {
+	case http.StatusTooManyRequests:
+		stats.Throttled++
+		cooldown = max(azureErr.RetryAfter, keyThrottledCooldown)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		stats.Rejected++
+		cooldown = keyRejectedCooldown
+	default:
+		return
+	}
+	until := time.Now().Add(cooldown)
+	stats.DownUntil = &until
+}
+
+// snapshot returns the stats of the server keys in the order of AZURE_KEY,
+// the keys themselves are left out as /status is public.
+func (p *keyPool) snapshot() []keyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result []keyStats
+	for _, key := range serverKeys() {
+		stats := keyStats{}
+		if s, ok := p.stats[key]; ok {
+			stats = *s
+			if stats.DownUntil != nil && time.Now().After(*stats.DownUntil) {
+				stats.DownUntil = nil
+			}
+		}
+		result = append(result, stats)
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+var keyVaultURL string
+var keyVaultSecret string
+var keyVaultRefresh = time.Hour
+
+var azureKeyMu sync.RWMutex
+
+func setupKeyVault() error {
+	if keyVaultURL == "" {
+		return nil
+	}
+	if keyVaultSecret == "" {
+		keyVaultSecret = "speech-key"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return loadKeyVaultSecret(ctx)
+}
+
+func refreshKeyVault(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// the previous key is kept when the key vault isn't reachable
+			if err := loadKeyVaultSecret(ctx); err != nil {
+				slog.Error("Failed to refresh azure key from key vault", "error", err)
+			}
+		}
+	}
+}
+
+func loadKeyVaultSecret(ctx context.Context) error {
+	secret, err := azure.KeyVaultSecret(ctx, keyVaultURL, keyVaultSecret)
+	if err != nil {
+		return err
+	}
+
+	azureKeyMu.Lock()
+	azureKey = secret
+	azureKeyMu.Unlock()
+	return nil
+}
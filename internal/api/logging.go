@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo is filled in by handlers and logged once the request is done.
+type requestInfo struct {
+	ID           string
+	Client       string
+	Cache        string
+	Voice        string
+	AzureLatency time.Duration
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *loggingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is needed for websockets, which don't look through Unwrap.
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests logs every request as json and echoes the request id
+// in the X-Request-Id header.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{ID: r.Header.Get("X-Request-Id")}
+		if info.ID == "" {
+			info.ID = newRequestID()
+		}
+		w.Header().Set("X-Request-Id", info.ID)
+
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		// health probes run every few seconds, only log them when they fail
+		if (r.URL.Path == "/healthz" || r.URL.Path == "/readyz") && lw.status == http.StatusOK {
+			return
+		}
+
+		attrs := []any{
+			"requestId", info.ID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", lw.status,
+			"size", lw.size,
+			"latency", time.Since(start),
+		}
+		if info.Client != "" {
+			attrs = append(attrs, "client", info.Client)
+		}
+		if info.Cache != "" {
+			attrs = append(attrs, "cache", info.Cache)
+		}
+		if info.Voice != "" {
+			attrs = append(attrs, "voice", info.Voice)
+		}
+		if info.AzureLatency > 0 {
+			attrs = append(attrs, "azureLatency", info.AzureLatency)
+		}
+		slog.Info("request", attrs...)
+	})
+}
+
+// requestInfoFrom returns the request info of the context, or a throwaway
+// one for requests that aren't logged.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// loudnessTarget is the integrated loudness in LUFS the synthesized audio is
+// normalized to before it's cached, 0 disables it
+var loudnessTarget int64
+
+// loudnessKey is the loudness part of the cache key, so audio cached before
+// the target was set or changed isn't served for it.
+func loudnessKey() string {
+	if loudnessTarget == 0 {
+		return ""
+	}
+	return strconv.FormatInt(loudnessTarget, 10)
+}
+
+// contentTypeFormats map the content types of the providers to the format
+// normalized audio is encoded in again
+var contentTypeFormats = map[string]string{
+	"audio/mpeg":  "mp3",
+	"audio/mp3":   "mp3",
+	"audio/ogg":   "opus",
+	"audio/webm":  "webm",
+	"audio/wav":   "wav",
+	"audio/x-wav": "wav",
+}
+
+// normalizedFormat returns the format the audio is encoded in after
+// normalizing it, the one of the request or the one it came in.
+func normalizedFormat(ttsRequest TTSRequest, contentType string) (string, bool) {
+	if ttsRequest.Format != "" {
+		return ttsRequest.Format, true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	format, ok := contentTypeFormats[strings.TrimSpace(strings.ToLower(mediaType))]
+	return format, ok
+}
+
+// sampleRate is the sample rate of the audio of the provider, loudnorm
+// resamples to 192kHz so it has to be set again.
+func sampleRate(provider string) string {
+	if provider == "azure" {
+		// the rate of outputFormat
+		return "16000"
+	}
+	return "24000"
+}
+
+// normalizeLoudness normalizes the audio of the provider to loudnessTarget
+// with the loudnorm filter of ffmpeg. Audio in a format it can't encode
+// again is returned as it is.
+func normalizeLoudness(ctx context.Context, audio io.ReadCloser, contentType string, ttsRequest TTSRequest) (io.ReadCloser, string, error) {
+	format, ok := normalizedFormat(ttsRequest, contentType)
+	target, known := audioFormats[format]
+	if !ok || !known {
+		slog.Warn("Can't normalize the loudness of the audio", "contentType", contentType)
+		return audio, contentType, nil
+	}
+	defer audio.Close()
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-i", "pipe:0",
+		"-af", fmt.Sprintf("loudnorm=I=%d:TP=-1.5:LRA=11", loudnessTarget),
+		"-ar", sampleRate(ttsRequest.Provider),
+	}
+	// the format can set its own sample rate, the last one is used
+	cmd := exec.CommandContext(ctx, ffmpegPath, append(append(args, target.args...), "pipe:1")...)
+	cmd.Stdin = audio
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	normalized, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		slog.Error("Normalizing the loudness failed", "error", err, "stderr", stderr.String())
+		return nil, "", errors.New("normalizing the loudness of the audio failed")
+	}
+	if len(normalized) == 0 {
+		return nil, "", errors.New("normalizing the loudness returned no audio")
+	}
+	if ttsRequest.Format == "" {
+		// it's still in the format of the provider
+		return io.NopCloser(bytes.NewReader(normalized)), contentType, nil
+	}
+	return io.NopCloser(bytes.NewReader(normalized)), target.contentType, nil
+}
+
+// normalizeEntry normalizes the audio of an entry that wasn't synthesized
+// with synthesize, e.g. over the websocket or the batch api.
+func normalizeEntry(ctx context.Context, entry cache.Entry, ttsRequest TTSRequest) (cache.Entry, error) {
+	if loudnessTarget == 0 {
+		return entry, nil
+	}
+	audio, contentType, err := normalizeLoudness(ctx, io.NopCloser(bytes.NewReader(entry.Audio)), entry.Type, ttsRequest)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	defer audio.Close()
+	if entry.Audio, err = io.ReadAll(audio); err != nil {
+		return cache.Entry{}, err
+	}
+	entry.Type = contentType
+	return entry, nil
+}
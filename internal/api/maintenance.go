@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// WarmResult is the outcome of Server.Warm.
+type WarmResult struct {
+	Total   int      `json:"total"`
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// PurgeFilter selects the entries deleted by Server.Purge, empty fields match all entries.
+type PurgeFilter struct {
+	Voice     string
+	Language  string
+	Namespace string
+	OlderThan time.Duration
+}
+
+// Warm synthesizes the requests into the permanent cache like POST /cache/warm,
+// but waits for all of them to finish.
+func (s *Server) Warm(ctx context.Context, requests []TTSRequest) WarmResult {
+	job := &warmJob{Status: "running", Total: len(requests), Created: time.Now()}
+	job.run(context.WithValue(ctx, clientKey{}, "admin"), requests)
+	return WarmResult{Total: job.Total, Skipped: job.Skipped, Failed: job.Failed, Errors: job.Errors}
+}
+
+// Export writes the permanent cache as a .tar.gz archive, the same as GET /cache/export.
+func (s *Server) Export(w io.Writer) error {
+	return writeExport(w)
+}
+
+// ExportDir writes the audio of the permanent cache to dir as files named
+// after their hash with a manifest.json, it returns how many entries were
+// exported.
+func (s *Server) ExportDir(dir string) (int, error) {
+	return exportDir(dir)
+}
+
+// ImportDir adds the audio files listed in the manifest.json of dir to the
+// permanent cache like POST /cache/import-dir and saves the cache file.
+func (s *Server) ImportDir(dir string) (ImportResult, error) {
+	result, err := importDir(dir)
+	if persist && result.Imported > 0 {
+		saveCache()
+	}
+	return result, err
+}
+
+// Backup uploads the permanent cache to backup_url like POST /cache/backups,
+// it returns the name of the backup.
+func (s *Server) Backup(ctx context.Context) (string, error) {
+	if backups == nil {
+		return "", errors.New("backups are disabled, set backup_url to enable them")
+	}
+	info, err := createBackup(ctx)
+	return info.Name, err
+}
+
+// Restore imports the backup into the permanent cache and saves the cache
+// file, the newest backup when name is empty. It returns the name of the
+// backup and how many entries were restored.
+func (s *Server) Restore(ctx context.Context, name string) (string, int, error) {
+	if backups == nil {
+		return "", 0, errors.New("backups are disabled, set backup_url to enable them")
+	}
+	return restoreBackup(ctx, name)
+}
+
+// Purge deletes the matching entries and saves the cache file,
+// it returns how many entries were deleted.
+func (s *Server) Purge(filter PurgeFilter) int {
+	deleted := purge(filter)
+	if persist {
+		saveCache()
+	}
+	return deleted
+}
+
+// Stats returns the same statistics as GET /status.
+func (s *Server) Stats() map[string]interface{} {
+	return statusStats()
+}
+
+func purge(filter PurgeFilter) int {
+	deleted := 0
+	for _, store := range []cache.Store{c, tempC} {
+		for key, entry := range store.Items() {
+			if filter.Voice != "" && entry.Voice != filter.Voice {
+				continue
+			}
+			if filter.Language != "" && !strings.EqualFold(entry.Language, filter.Language) {
+				continue
+			}
+			if filter.Namespace != "" && entry.Namespace != filter.Namespace {
+				continue
+			}
+			if filter.OlderThan > 0 && time.Since(entry.Created) < filter.OlderThan {
+				continue
+			}
+			store.Delete(key)
+			if store == c {
+				cacheDeleted(key)
+			}
+			hits.remove(key)
+			deleted++
+		}
+	}
+	return deleted
+}
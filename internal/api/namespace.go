@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+type tenantKey struct{}
+
+// tenantNamespaces gives every api key its own cache namespace, named
+// after the client name of the key
+var tenantNamespaces bool
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withTenant sets the tenant of the authenticated client when the cache is
+// partitioned by api key.
+func withTenant(ctx context.Context, name string) context.Context {
+	if !tenantNamespaces {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, name)
+}
+
+// resolveNamespace returns the namespace the audio of the request is cached
+// in. Tenants can only use namespaces inside their own, requests without a
+// tenant (the admin api, signed urls) use the namespace as it is.
+func resolveNamespace(ctx context.Context, namespace string) (string, error) {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	if tenant == "" {
+		return namespace, nil
+	}
+	if namespace == "" {
+		return tenant, nil
+	}
+	if !namespacePattern.MatchString(namespace) {
+		return "", fieldError("invalid_namespace", "namespace", "namespace must be 1 to 64 letters, digits, dots, dashes or underscores")
+	}
+	return tenant + "/" + namespace, nil
+}
+
+// namespaceStats describes the cached audio of a namespace
+type namespaceStats struct {
+	Namespace string `json:"namespace"`
+	Items     int    `json:"items"`
+	TempItems int    `json:"tempItems"`
+	Bytes     int64  `json:"bytes"`
+	Hits      int64  `json:"hits"`
+}
+
+// handleNamespaceStats lists the size and hit counts of the cache per namespace.
+func handleNamespaceStats(w http.ResponseWriter, r *http.Request) {
+	byNamespace := map[string]*namespaceStats{}
+	for _, store := range []struct {
+		store     cache.Store
+		permanent bool
+	}{{c, true}, {tempC, false}} {
+		for key, entry := range store.store.Items() {
+			stats := byNamespace[entry.Namespace]
+			if stats == nil {
+				stats = &namespaceStats{Namespace: entry.Namespace}
+				byNamespace[entry.Namespace] = stats
+			}
+			if store.permanent {
+				stats.Items++
+			} else {
+				stats.TempItems++
+			}
+			stats.Bytes += cache.Size(key, entry) - int64(len(key))
+			stats.Hits += hits.get(key).Hits
+		}
+	}
+
+	items := make([]namespaceStats, 0, len(byNamespace))
+	for _, stats := range byNamespace {
+		items = append(items, *stats)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Namespace < items[j].Namespace
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"namespaces": items,
+	})
+}
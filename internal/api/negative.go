@@ -0,0 +1,58 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// failureTTL is how long requests azure rejected are answered with the
+// same error without asking azure again, 0 disables it
+var failureTTL time.Duration
+
+var failuresC = gocache.New(time.Minute, 5*time.Minute)
+
+// isDeterministic reports whether azure will fail the same request again,
+// e.g. an invalid voice or bad ssml. Credentials and throttling can change.
+func isDeterministic(err error) bool {
+	var azureErr *azure.Error
+	if !errors.As(err, &azureErr) || azureErr.StatusCode < 400 || azureErr.StatusCode >= 500 {
+		return false
+	}
+	switch azureErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return true
+}
+
+// failureKey identifies the request together with the region and the
+// credentials it's sent with, clients can choose both so the failure of one
+// of them isn't served to the others.
+func failureKey(ttsRequest TTSRequest, key string) string {
+	credentials := sha256.Sum256([]byte(ttsRequest.AzureKey))
+	return key + "/" + ttsRequest.AzureRegion + "/" + hex.EncodeToString(credentials[:8])
+}
+
+// rememberFailure keeps the error of the request if azure would fail it again.
+func rememberFailure(ttsRequest TTSRequest, key string, err error) {
+	if failureTTL > 0 && isDeterministic(err) {
+		failuresC.Set(failureKey(ttsRequest, key), err, failureTTL)
+	}
+}
+
+// recentFailure returns the error of the request if azure rejected it recently.
+func recentFailure(ttsRequest TTSRequest, key string) (error, bool) {
+	if failureTTL <= 0 {
+		return nil, false
+	}
+	if err, ok := failuresC.Get(failureKey(ttsRequest, key)); ok {
+		return err.(error), true
+	}
+	return nil, false
+}
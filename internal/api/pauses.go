@@ -0,0 +1,40 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// azure ignores longer breaks
+const maxPause = 5000
+
+// pauseMarker is replaced with a break in the text, e.g. [[pause:500]]
+var pauseMarker = regexp.MustCompile(`\[\[pause:(\d+)\]\]`)
+
+// addPauses replaces the pause markers in the ssml text and adds
+// the leading and trailing breaks.
+func addPauses(text string, leading, trailing int) string {
+	text = pauseMarker.ReplaceAllStringFunc(text, func(marker string) string {
+		ms, _ := strconv.Atoi(pauseMarker.FindStringSubmatch(marker)[1])
+		return breakTag(min(ms, maxPause))
+	})
+	if leading > 0 {
+		text = breakTag(leading) + text
+	}
+	if trailing > 0 {
+		text += breakTag(trailing)
+	}
+	return text
+}
+
+func breakTag(ms int) string {
+	return fmt.Sprintf("<break time='%dms'/>", ms)
+}
+
+func pauseKey(ms int) string {
+	if ms == 0 {
+		return ""
+	}
+	return strconv.Itoa(ms)
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+var cacheDir string
+var cacheFile string
+
+var saveInterval time.Duration
+var saveMu sync.Mutex
+
+// dirty is set when the permanent cache has changes that are not saved yet
+var dirty atomic.Bool
+
+func init() {
+	// registered under the name it had before the packages were split,
+	// so existing cache files can still be loaded
+	gob.RegisterName("main.CacheEntry", cache.Entry{})
+}
+
+func setupCacheDir() error {
+	if cacheDir == "" {
+		cacheDir = "."
+	}
+	if cacheFile == "" {
+		cacheFile = filepath.Join(cacheDir, "cache-data.bin")
+	}
+	return os.MkdirAll(filepath.Dir(cacheFile), 0o755)
+}
+
+func loadCache() {
+	items, outdated := readCacheFile()
+	if replayed := replayWAL(items); replayed > 0 {
+		slog.Info("Replayed cache log", "records", replayed)
+		// compact the log into a new snapshot soon
+		outdated = true
+	}
+	if !outdated {
+		saveMu.Lock()
+		lastSnapshot = time.Now()
+		saveMu.Unlock()
+	}
+
+	skipped := 0
+	corrupted := 0
+	expired := 0
+	entries := make(map[string]cache.Entry, len(items))
+	for key, value := range items {
+		if !isCacheKey(key) {
+			// entries saved before voice parameters were part of the key
+			// can't be attributed to a voice, so they are dropped
+			skipped++
+			continue
+		}
+		entry, ok := value.Object.(cache.Entry)
+		if !ok {
+			corrupted++
+			continue
+		}
+		if _, ok := remainingTTL(entry); !ok {
+			expired++
+			continue
+		}
+		entries[key] = entry
+	}
+
+	// add the least recently accessed entries first, so they're the
+	// first ones evicted when the cache size is limited
+	keys := slices.Collect(maps.Keys(entries))
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := hits.get(keys[i]), hits.get(keys[j])
+		if !a.LastAccessed.Equal(b.LastAccessed) {
+			return a.LastAccessed.Before(b.LastAccessed)
+		}
+		return entries[keys[i]].Created.Before(entries[keys[j]].Created)
+	})
+	for _, key := range keys {
+		ttl, _ := remainingTTL(entries[key])
+		c.Set(key, entries[key], ttl)
+	}
+	hits.keep(entries)
+	if outdated {
+		// rewrite older files in the current format
+		dirty.Store(true)
+	}
+
+	if corrupted > 0 {
+		slog.Warn("Skipped corrupted cache entries", "count", corrupted)
+	}
+
+	if expired > 0 {
+		slog.Info("Skipped expired cache entries", "count", expired)
+	}
+
+	if skipped > 0 {
+		slog.Info("Skipped legacy cache entries", "count", skipped)
+	}
+	slog.Info("Cache loaded from binary file", "items", c.Stats().Items)
+}
+
+// readCacheFile returns the entries of the last snapshot, outdated is set
+// when it has to be rewritten in the current format.
+func readCacheFile() (items map[string]gocache.Item, outdated bool) {
+	items = make(map[string]gocache.Item)
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		slog.Info("Cache file not found, starting with empty cache")
+		return items, false
+	}
+
+	// files before version 2 are encrypted as a whole, newer ones by block
+	original := data
+	data, err = cache.Unseal(data)
+	damaged := 0
+	if err == nil {
+		var decoded map[string]gocache.Item
+		decoded, outdated, damaged, err = decodeCacheFile(data)
+		if decoded != nil {
+			items = decoded
+		}
+	}
+	if err != nil {
+		// keep the corrupted file for inspection instead of overwriting it on the next save
+		slog.Error("Failed to decode cache file, starting with empty cache", "error", err)
+		if err := os.Rename(cacheFile, cacheFile+".corrupt"); err != nil {
+			slog.Error("Failed to move corrupted cache file", "error", err)
+		}
+		return make(map[string]gocache.Item), false
+	}
+	if damaged > 0 {
+		// the next save writes the file without the corrupted parts, keep
+		// a copy of it for inspection
+		slog.Warn("Skipped corrupted parts of the cache file", "count", damaged, "loaded", len(items))
+		if err := os.WriteFile(cacheFile+".corrupt", original, 0o644); err != nil {
+			slog.Error("Failed to copy corrupted cache file", "error", err)
+		}
+		outdated = true
+	}
+	return items, outdated
+}
+
+func saveCache() {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	dirty.Store(false)
+	rotateWAL()
+
+	items := make(map[string]gocache.Item)
+	for key, entry := range c.Items() {
+		items[key] = gocache.Item{Object: entry}
+	}
+
+	// write to a temporary file and rename it, so a crash mid-save
+	// doesn't leave a truncated cache file behind
+	data, err := encodeCacheFile(items)
+	if err != nil {
+		slog.Error("Failed to save cache", "error", err)
+		dirty.Store(true)
+		return
+	}
+	if err := cache.WriteFileAtomic(cacheFile, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		slog.Error("Failed to save cache", "error", err)
+		dirty.Store(true)
+		return
+	}
+
+	lastSnapshot = time.Now()
+	if err := os.Remove(walSnapshotFile()); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove cache log", "error", err)
+	}
+	slog.Info("Cache saved to binary file")
+}
+
+// runPersister saves the cache and usage every interval if they have changed.
+func runPersister(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saveChanges()
+		}
+	}
+}
+
+func saveChanges() {
+	if persist && dirty.Load() && snapshotDue() {
+		saveCache()
+	}
+	if hits.dirty.Load() {
+		hits.save()
+	}
+	if usage.dirty.Load() {
+		usage.save()
+	}
+}
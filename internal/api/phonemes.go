@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var phonemeAlphabets = []string{"ipa", "sapi", "ups", "x-sampa"}
+
+// applyPhonemes escapes the text and wraps the whole words that have a
+// pronunciation in phoneme tags, matching case-insensitively.
+func applyPhonemes(text string, phonemes map[string]string, alphabet string) string {
+	var words []string
+	lookup := map[string]string{}
+	for word, ph := range phonemes {
+		if word == "" {
+			continue
+		}
+		words = append(words, regexp.QuoteMeta(word))
+		lookup[strings.ToLower(word)] = ph
+	}
+	if len(words) == 0 {
+		return escapeXML(text)
+	}
+	// longer words first so phrases win over the words in them
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	pattern := regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		if !isWordBoundary(text, start, end) {
+			continue
+		}
+		word := text[start:end]
+		b.WriteString(escapeXML(text[last:start]))
+		fmt.Fprintf(&b, "<phoneme alphabet='%s' ph='%s'>%s</phoneme>", escapeXML(alphabet), escapeXML(lookup[strings.ToLower(word)]), escapeXML(word))
+		last = end
+	}
+	b.WriteString(escapeXML(text[last:]))
+	return b.String()
+}
+
+func isWordBoundary(text string, start, end int) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWord(before) {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWord(after) {
+		return false
+	}
+	return true
+}
+
+// canonicalPhonemes returns the phonemes in a stable order for the cache key.
+func canonicalPhonemes(phonemes map[string]string) string {
+	var parts []string
+	for word, ph := range phonemes {
+		parts = append(parts, word+"="+ph)
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, "\x1f")
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TTSProvider synthesizes speech with a text-to-speech service.
+type TTSProvider interface {
+	// Validate rejects requests using features the provider doesn't support.
+	Validate(r TTSRequest) error
+	// Synthesize returns the audio stream and its content type.
+	Synthesize(ctx context.Context, r TTSRequest) (io.ReadCloser, string, error)
+}
+
+// providerError is the status code a provider other than azure failed with.
+type providerError struct {
+	Provider   string
+	StatusCode int
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("%s returned %d", e.Provider, e.StatusCode)
+}
+
+// providerFailed responds with the error a provider other than azure
+// returned, so clients can tell throttling and invalid requests apart.
+func providerFailed(w http.ResponseWriter, err error) bool {
+	var providerErr *providerError
+	if !errors.As(err, &providerErr) {
+		return false
+	}
+	switch {
+	case providerErr.StatusCode == http.StatusTooManyRequests:
+		writeError(w, &apiError{Code: "provider_throttled", Message: err.Error()}, http.StatusTooManyRequests)
+	case providerErr.StatusCode == http.StatusBadRequest:
+		writeError(w, &apiError{Code: "provider_rejected", Message: err.Error()}, http.StatusBadRequest)
+	default:
+		writeError(w, &apiError{Code: "provider_error", Message: err.Error()}, http.StatusBadGateway)
+	}
+	return true
+}
+
+// defaultProvider is used for requests that don't set provider
+var defaultProvider string
+
+var providers = map[string]TTSProvider{
+	"azure": azureProvider{},
+}
+
+// setupProviders registers the providers that are configured.
+func setupProviders() error {
+	if googleAPIKey != "" {
+		providers["google"] = googleProvider{}
+	}
+	if openaiAPIKey != "" {
+		if openaiModel == "" {
+			openaiModel = "tts-1"
+		}
+		providers["openai"] = openaiProvider{}
+	}
+
+	if defaultProvider == "" {
+		defaultProvider = "azure"
+	}
+	if _, ok := providers[defaultProvider]; !ok {
+		return errors.New("provider " + defaultProvider + " is unknown or not configured")
+	}
+	return nil
+}
+
+// providerKey is the provider part of the cache key, empty for azure
+// so keys from before there were other providers stay the same.
+func providerKey(provider string) string {
+	switch provider {
+	case "azure":
+		return ""
+	case "openai":
+		// the models sound different
+		return provider + "/" + openaiModel
+	}
+	return provider
+}
+
+// speakingRate maps the azure rate (a multiplier or a relative percentage)
+// to a speed multiplier between 0.25 and 4 for the other providers.
+func speakingRate(rate string) float64 {
+	if rate == "" {
+		rate = defaultRate
+	}
+	if percent, ok := strings.CutSuffix(rate, "%"); ok {
+		if value, err := strconv.ParseFloat(percent, 64); err == nil {
+			return min(max(1+value/100, 0.25), 4)
+		}
+	}
+	if value, err := strconv.ParseFloat(rate, 64); err == nil {
+		return min(max(value, 0.25), 4)
+	}
+	return 1
+}
+
+type azureProvider struct{}
+
+func (azureProvider) Validate(r TTSRequest) error {
+	return nil
+}
+
+func (azureProvider) Synthesize(ctx context.Context, r TTSRequest) (io.ReadCloser, string, error) {
+	resp, err := requestAzure(ctx, r)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+var googleAPIKey string
+
+type googleProvider struct{}
+
+func (googleProvider) Validate(r TTSRequest) error {
+	switch {
+	case len(r.Segments) > 0:
+		return fieldError("unsupported_by_provider", "segments", "segments are not supported by the google provider")
+	case r.DeploymentID != "" || r.LexiconURL != "" || len(r.Phonemes) > 0:
+		return fieldError("unsupported_by_provider", "", "deploymentId, lexiconUrl and phonemes are not supported by the google provider")
+	case r.StyleDegree != "" || r.Role != "" || r.Style != "":
+		return fieldError("unsupported_by_provider", "", "style, styleDegree and role are not supported by the google provider")
+	case r.AllowMarkup || r.LeadingPause > 0 || r.TrailingPause > 0:
+		return fieldError("unsupported_by_provider", "", "allowMarkup and pauses are not supported by the google provider")
+	}
+	return nil
+}
+
+func (googleProvider) Synthesize(ctx context.Context, r TTSRequest) (io.ReadCloser, string, error) {
+	input := map[string]string{"text": r.Text}
+	if r.SSML != "" {
+		input = map[string]string{"ssml": r.SSML}
+	}
+	voice := map[string]string{"languageCode": r.Language, "name": r.Name}
+	if r.Gender != "" {
+		voice["ssmlGender"] = strings.ToUpper(r.Gender)
+	}
+	audioConfig := map[string]interface{}{
+		"audioEncoding": "MP3",
+		"speakingRate":  speakingRate(r.Rate),
+	}
+	if pitch, ok := googleSemitones(r.Pitch); ok {
+		audioConfig["pitch"] = pitch
+	}
+	if volume, ok := strings.CutSuffix(strings.ToLower(r.Volume), "db"); ok {
+		if gain, err := strconv.ParseFloat(volume, 64); err == nil {
+			audioConfig["volumeGainDb"] = gain
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"input": input, "voice": voice, "audioConfig": audioConfig})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://texttospeech.googleapis.com/v1/text:synthesize?key="+url.QueryEscape(googleAPIKey), bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &providerError{Provider: "Google", StatusCode: resp.StatusCode}
+	}
+
+	var result struct {
+		AudioContent string `json:"audioContent"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+	audio, err := base64.StdEncoding.DecodeString(result.AudioContent)
+	if err != nil {
+		return nil, "", err
+	}
+	return io.NopCloser(bytes.NewReader(audio)), "audio/mpeg", nil
+}
+
+// googleSemitones maps an azure pitch in semitones, e.g. +2st, to google.
+func googleSemitones(pitch string) (float64, bool) {
+	semitones, ok := strings.CutSuffix(pitch, "st")
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(semitones, 64)
+	if err != nil {
+		return 0, false
+	}
+	return min(max(value, -20), 20), true
+}
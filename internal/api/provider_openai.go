@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+var openaiAPIKey string
+var openaiModel string
+
+type openaiProvider struct{}
+
+func (openaiProvider) Validate(r TTSRequest) error {
+	switch {
+	case r.SSML != "" || r.AllowMarkup || len(r.Segments) > 0:
+		return fieldError("unsupported_by_provider", "", "ssml, allowMarkup and segments are not supported by the openai provider")
+	case r.DeploymentID != "" || r.LexiconURL != "" || len(r.Phonemes) > 0:
+		return fieldError("unsupported_by_provider", "", "deploymentId, lexiconUrl and phonemes are not supported by the openai provider")
+	case r.StyleDegree != "" || r.Role != "" || r.Pitch != "" || r.Volume != "":
+		return fieldError("unsupported_by_provider", "", "styleDegree, role, pitch and volume are not supported by the openai provider")
+	case r.LeadingPause > 0 || r.TrailingPause > 0:
+		return fieldError("unsupported_by_provider", "", "pauses are not supported by the openai provider")
+	case r.Style != "" && openaiModel != "gpt-4o-mini-tts":
+		return fieldError("unsupported_by_provider", "style", "style is only supported with the gpt-4o-mini-tts model")
+	}
+	return nil
+}
+
+// Synthesize uses name as the openai voice, or picks one by gender,
+// and style as the instructions for the gpt-4o-mini-tts model.
+func (openaiProvider) Synthesize(ctx context.Context, r TTSRequest) (io.ReadCloser, string, error) {
+	body := map[string]interface{}{
+		"model":           openaiModel,
+		"input":           r.Text,
+		"voice":           openaiVoice(r),
+		"speed":           speakingRate(r.Rate),
+		"response_format": "mp3",
+	}
+	if r.Style != "" {
+		body["instructions"] = r.Style
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.openai.com/v1/audio/speech", bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+openaiAPIKey)
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", &providerError{Provider: "OpenAI", StatusCode: resp.StatusCode}
+	}
+	return resp.Body, "audio/mpeg", nil
+}
+
+func openaiVoice(r TTSRequest) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	switch strings.ToLower(r.Gender) {
+	case "female":
+		return "nova"
+	case "male":
+		return "onyx"
+	}
+	return "alloy"
+}
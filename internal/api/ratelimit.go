@@ -0,0 +1,126 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client, refilled continuously up to
+// perMinute tokens. A limit of 0 disables it.
+type rateLimiter struct {
+	perMinute float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var requestLimiter = newRateLimiter(0)
+var charLimiter = newRateLimiter(0)
+
+func newRateLimiter(perMinute float64) *rateLimiter {
+	return &rateLimiter{perMinute: perMinute, buckets: make(map[string]*tokenBucket)}
+}
+
+// take removes n tokens from the client bucket, or returns how long to
+// wait until there are enough of them.
+func (l *rateLimiter) take(client string, n float64) (bool, time.Duration) {
+	if l.perMinute <= 0 {
+		return true, 0
+	}
+	// requests bigger than the bucket are let through once it is full
+	n = min(n, l.perMinute)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.buckets) > 10000 {
+		l.cleanup(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.perMinute, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.last = now
+
+	if bucket.tokens < n {
+		missing := n - bucket.tokens
+		return false, time.Duration(missing / l.perMinute * float64(time.Minute))
+	}
+	bucket.tokens -= n
+	return true, 0
+}
+
+// charge removes n tokens from the client bucket even when there aren't
+// enough, for usage that's only known afterwards. The next take waits
+// until the bucket is refilled.
+func (l *rateLimiter) charge(client string, n float64) {
+	if l.perMinute <= 0 {
+		return
+	}
+	n = min(n, l.perMinute)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.perMinute, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now) - n
+	bucket.last = now
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(bucket.last).Minutes()
+	return math.Min(l.perMinute, bucket.tokens+elapsed*l.perMinute)
+}
+
+// cleanup drops full buckets, they are the same as new ones.
+func (l *rateLimiter) cleanup(now time.Time) {
+	for client, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.perMinute {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitID identifies the client by api key name or ip address.
+func rateLimitID(r *http.Request) string {
+	if name := clientName(r.Context()); name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func rateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, &apiError{Code: "rate_limited", Message: "rate limit exceeded"}, http.StatusTooManyRequests)
+}
+
+func limitRequests(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := requestLimiter.take(rateLimitID(r), 1); !ok {
+			rateLimited(w, retryAfter)
+			return
+		}
+		next(w, r)
+	}
+}
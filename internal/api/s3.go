@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+var s3Endpoint string
+var s3Region string
+var s3Bucket string
+var s3Prefix string
+var s3AccessKey string
+var s3SecretKey string
+
+func newS3Store() (cache.Store, error) {
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+	return cache.NewS3(client, s3Bucket, s3Prefix, time.Minute*10)
+}
+
+// newS3Client connects to the s3 compatible endpoint. Without an access key
+// the credentials are taken from the AWS_ environment variables, the
+// shared credentials file or the instance role.
+func newS3Client() (*minio.Client, error) {
+	creds := credentials.NewStaticV4(s3AccessKey, s3SecretKey, "")
+	if s3AccessKey == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	endpoint, secure := s3Endpoint, true
+	if host, ok := strings.CutPrefix(endpoint, "http://"); ok {
+		endpoint, secure = host, false
+	}
+	endpoint = strings.TrimSuffix(strings.TrimPrefix(endpoint, "https://"), "/")
+
+	return minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: s3Region,
+	})
+}
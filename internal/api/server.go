@@ -0,0 +1,948 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	gocache "github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+type TTSRequest struct {
+	Text        string `json:"text"`
+	SSML        string `json:"ssml"`
+	Language    string `json:"language"`
+	Gender      string `json:"gender"`
+	Name        string `json:"name"`
+	Style       string `json:"style"`
+	StyleDegree string `json:"styleDegree"`
+	Role        string `json:"role"`
+	Rate        string `json:"rate"`
+	Pitch       string `json:"pitch"`
+	Volume      string `json:"volume"`
+	AzureKey    string `json:"azureKey"`
+	AzureRegion string `json:"azureRegion"`
+	ShouldCache bool   `json:"shouldCache"`
+	AllowMarkup bool   `json:"allowMarkup"`
+	CallbackURL string `json:"callbackUrl"`
+	Split       bool   `json:"split"`
+	// DeploymentID is the endpoint id of a custom neural voice
+	DeploymentID string `json:"deploymentId"`
+	LexiconURL   string `json:"lexiconUrl"`
+	// Phonemes maps words of the text to their pronunciation
+	Phonemes        map[string]string `json:"phonemes"`
+	PhonemeAlphabet string            `json:"phonemeAlphabet"`
+	// Segments are synthesized as a dialogue instead of Text
+	Segments []Segment `json:"segments"`
+	// pauses before and after the audio in milliseconds
+	LeadingPause  int `json:"leadingPauseMs"`
+	TrailingPause int `json:"trailingPauseMs"`
+	// Provider is the text-to-speech service, default is TTS_PROVIDER
+	Provider string `json:"provider"`
+	// ForceRefresh synthesizes the audio even if it is cached and replaces
+	// the cached entry
+	ForceRefresh bool `json:"forceRefresh"`
+	// TTLSeconds is how long the audio is cached, 0 means it doesn't
+	// expire. Setting it implies ShouldCache, default is CACHE_TTL
+	TTLSeconds *int `json:"ttlSeconds"`
+	// Namespace partitions the cache, requests of tenants are cached in
+	// the namespace of their api key
+	Namespace string `json:"namespace"`
+	// Format is the encoding the audio is transcoded to, e.g. opus
+	Format string `json:"format"`
+}
+
+const outputFormat = "audio-16khz-64kbitrate-mono-mp3"
+
+var c cache.Store
+var tempC cache.Store
+var synthesisGroup singleflight.Group
+var errStreamInterrupted = errors.New("azure response interrupted")
+var backend string
+var blobDir string
+var boltFile string
+var redisURL string
+var storageURL string
+var maxCacheBytes int64
+var maxCacheItems int64
+var hotCacheBytes int64
+var hotCacheItems int64
+var azureKey string
+var azureRegion string
+var azureAuth string
+var allowClientCredentials bool
+var allowMarkup bool
+var persist bool
+
+// Server serves the http api, it's configured with package level state so
+// there can only be one per process.
+type Server struct {
+	handler http.Handler
+}
+
+// New applies the config, loads the cache and returns the server.
+func New(cfg Config) (*Server, error) {
+	if err := applyConfig(cfg); err != nil {
+		return nil, err
+	}
+	if err := setupKeyVault(); err != nil {
+		return nil, fmt.Errorf("failed to read azure key from key vault: %w", err)
+	}
+	if err := setupCacheDir(); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := setupEncryption(); err != nil {
+		return nil, err
+	}
+	if err := setupStores(); err != nil {
+		return nil, err
+	}
+	if err := setupBackups(); err != nil {
+		return nil, fmt.Errorf("invalid backup_url: %w", err)
+	}
+
+	hits.load()
+	if persist {
+		loadCache()
+	} else if backend != "redis" && persistentBackend() && c.Stats().Items == 0 {
+		// move the entries of the cache file over when switching backends
+		loadCache()
+	}
+	if err := openWAL(); err != nil {
+		return nil, fmt.Errorf("failed to open cache log: %w", err)
+	}
+	usage.load()
+	ready.Store(true)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tts", traceRequests("tts", requireAPIKey(limitRequests(handleTTSRequest))))
+	mux.HandleFunc("GET /tts/ws", traceRequests("tts.ws", requireAPIKey(handleTTSWebSocket)))
+	mux.HandleFunc("/tts/timings", traceRequests("tts.timings", requireAPIKey(limitRequests(handleTimingsRequest))))
+	mux.HandleFunc("GET /tts.twiml", traceRequests("tts.twiml", requireAPIKey(limitRequests(handleTwiML))))
+	mux.HandleFunc("/tts/captions", traceRequests("tts.captions", requireAPIKey(limitRequests(handleCaptionsRequest))))
+	mux.HandleFunc("POST /stt", traceRequests("stt", requireAPIKey(limitRequests(handleSTTRequest))))
+	mux.HandleFunc("/translate-tts", traceRequests("translate-tts", requireAPIKey(limitRequests(handleTranslateTTSRequest))))
+	mux.HandleFunc("/status", handleStatusRequest)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("/voices", requireAPIKey(limitRequests(handleVoicesRequest)))
+	mux.HandleFunc("/usage", requireAPIKey(handleUsageRequest))
+	mux.HandleFunc("POST /jobs", requireAPIKey(limitRequests(handleCreateJob)))
+	mux.HandleFunc("GET /jobs/{id}", requireAPIKey(handleGetJob))
+	mux.HandleFunc("GET /jobs/{id}/audio", requireAPIKey(handleGetJobAudio))
+	mux.HandleFunc("POST /cache/check", requireAPIKey(limitRequests(handleCheckCache)))
+	mux.HandleFunc("GET /cache", requireAdmin(handleListCache))
+	mux.HandleFunc("GET /stats/top", requireAdmin(handleTopEntries))
+	mux.HandleFunc("GET /stats/namespaces", requireAdmin(handleNamespaceStats))
+	mux.HandleFunc("GET /cache/export", requireAdmin(handleExportCache))
+	mux.HandleFunc("POST /cache/import", requireAdmin(handleImportCache))
+	mux.HandleFunc("POST /cache/import-dir", requireAdmin(handleImportDir))
+	mux.HandleFunc("GET /cache/backups", requireAdmin(handleListBackups))
+	mux.HandleFunc("POST /cache/backups", requireAdmin(handleCreateBackup))
+	mux.HandleFunc("POST /cache/backups/restore", requireAdmin(handleRestoreBackup))
+	mux.HandleFunc("POST /cache/warm", requireAdmin(handleWarmCache))
+	mux.HandleFunc("GET /cache/warm/{id}", requireAdmin(handleWarmStatus))
+	mux.HandleFunc("POST /cache/refresh", requireAdmin(handleRefreshCache))
+	mux.HandleFunc("POST /cache/flush", requireAdmin(handleFlushCache))
+	mux.HandleFunc("DELETE /cache/{key}", requireAdmin(handleDeleteCacheEntry))
+	mux.HandleFunc("DELETE /cache", requireAdmin(handleDeleteCacheQuery))
+
+	return &Server{handler: logRequests(handleCORS(mux))}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// RequireAdmin only lets requests with the ADMIN_KEY through to the
+// handler, e.g. for debug endpoints of the program embedding the server.
+func (s *Server) RequireAdmin(handler http.Handler) http.Handler {
+	return requireAdmin(handler.ServeHTTP)
+}
+
+// Run saves the cache periodically and refreshes the key vault secret
+// until the context is cancelled.
+func (s *Server) Run(ctx context.Context) {
+	if keyVaultURL != "" {
+		go refreshKeyVault(ctx, keyVaultRefresh)
+	}
+	if backups != nil && backupInterval > 0 {
+		go runBackups(ctx, backupInterval)
+	}
+	runPersister(ctx, saveInterval)
+}
+
+// Close saves the changes to the cache, call it after the requests are drained.
+func (s *Server) Close() {
+	// compact the log on shutdown so the next start doesn't replay it
+	if persist && dirty.Load() {
+		saveCache()
+	}
+	saveChanges()
+	closeWAL()
+}
+
+func setupStores() error {
+	switch backend {
+	case "", "memory":
+		c = cache.NewMemory(gocache.NoExpiration, time.Minute*10)
+		tempC = cache.NewMemory(tempCacheTTL, time.Minute*10)
+	case "disk":
+		if blobDir == "" {
+			blobDir = filepath.Join(cacheDir, "cache-blobs")
+		}
+		store, err := cache.NewDisk(blobDir)
+		if err != nil {
+			return fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		c = store
+		tempC = cache.NewMemory(tempCacheTTL, time.Minute*10)
+	case "bolt":
+		if boltFile == "" {
+			boltFile = filepath.Join(cacheDir, "cache.db")
+		}
+		store, err := cache.NewBolt(boltFile, time.Minute*10)
+		if err != nil {
+			return fmt.Errorf("failed to open bolt file: %w", err)
+		}
+		c = store
+		tempC = cache.NewMemory(tempCacheTTL, time.Minute*10)
+	case "azureblob":
+		container, err := azure.NewContainer(storageURL)
+		if err != nil {
+			return fmt.Errorf("invalid azure_storage_url: %w", err)
+		}
+		store, err := cache.NewAzureBlob(container, time.Minute*10)
+		if err != nil {
+			return fmt.Errorf("failed to open azure storage container: %w", err)
+		}
+		c = store
+		tempC = cache.NewMemory(tempCacheTTL, time.Minute*10)
+	case "s3":
+		store, err := newS3Store()
+		if err != nil {
+			return fmt.Errorf("failed to open s3 bucket: %w", err)
+		}
+		c = store
+		tempC = cache.NewMemory(tempCacheTTL, time.Minute*10)
+	case "redis":
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return fmt.Errorf("invalid redis_url: %w", err)
+		}
+		client := redis.NewClient(opts)
+		c = cache.NewRedis(client, "tts:")
+		tempC = cache.NewRedis(client, "tts-temp:")
+	}
+
+	// the memory and redis backends are fast enough without it
+	if (hotCacheBytes > 0 || hotCacheItems > 0) && backend != "" && backend != "memory" && backend != "redis" {
+		c = cache.NewTiered(c, hotCacheBytes, int(hotCacheItems), blobDir)
+	}
+	if (maxCacheBytes > 0 || maxCacheItems > 0) && !persistentBackend() {
+		c = cache.NewLRU(c, maxCacheBytes, int(maxCacheItems))
+	}
+	return nil
+}
+
+// persistentBackend reports whether the backend writes every entry as it's
+// added, so there's no cache file to save and no size limit to enforce.
+func persistentBackend() bool {
+	return backend == "redis" || backend == "bolt" || backend == "azureblob" || backend == "s3"
+}
+
+func cacheKey(r TTSRequest) string {
+	h := sha256.New()
+	for _, part := range []string{r.Text, r.Language, r.Gender, r.Name, r.Style, outputFormat} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	// optional parameters are only part of the key when set,
+	// so keys of requests that don't use them stay the same
+	optional := [][2]string{
+		{"ssml", r.SSML},
+		{"rate", r.Rate},
+		{"pitch", r.Pitch},
+		{"volume", r.Volume},
+		{"deployment", r.DeploymentID},
+		{"styledegree", r.StyleDegree},
+		{"role", r.Role},
+		{"lexicon", r.LexiconURL},
+		{"phonemes", canonicalPhonemes(r.Phonemes)},
+		{"alphabet", r.PhonemeAlphabet},
+		{"segments", canonicalSegments(r.Segments)},
+		{"leadingpause", pauseKey(r.LeadingPause)},
+		{"trailingpause", pauseKey(r.TrailingPause)},
+		{"provider", providerKey(r.Provider)},
+		{"namespace", r.Namespace},
+		{"format", r.Format},
+		{"loudness", loudnessKey()},
+	}
+	if r.AllowMarkup {
+		optional = append(optional, [2]string{"markup", "true"})
+	}
+	for _, part := range optional {
+		if part[1] != "" {
+			fmt.Fprintf(h, "%s=%s", part[0], part[1])
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func isCacheKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+func handleStatusRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusStats())
+}
+
+func statusStats() map[string]interface{} {
+	stats := c.Stats()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	result := map[string]interface{}{
+		"itemsCount":     stats.Items,
+		"cacheMemory":    fmt.Sprintf("%f mb", float64(stats.Bytes)/1024/1024),
+		"evictions":      stats.Evictions,
+		"failovers":      failovers.Load(),
+		"synthesizing":   len(synthesisSlots),
+		"failedRequests": failuresC.ItemCount(),
+		"alloc":          fmt.Sprintf("%f mb", float64(m.Alloc)/1024/1024),
+		"totalAlloc":     fmt.Sprintf("%f mb", float64(m.TotalAlloc)/1024/1024),
+		"sys":            fmt.Sprintf("%f mb", float64(m.Sys)/1024/1024),
+		"numGC":          m.NumGC,
+	}
+	if len(serverKeys()) > 1 {
+		result["azureKeys"] = serverKeyPool.snapshot()
+	}
+	for name, value := range counters.snapshot() {
+		result[name] = value
+	}
+	return result
+}
+
+// openEntry returns a reader over the entry audio.
+func openEntry(entry cache.Entry) (io.ReadSeekCloser, error) {
+	return cache.Open(entry, blobDir)
+}
+
+// serveEntry responds with the cached audio and its Content-Length,
+// supporting range requests so browsers can seek in it.
+func serveEntry(w http.ResponseWriter, r *http.Request, entry cache.Entry) {
+	audio, err := openEntry(entry)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer audio.Close()
+
+	w.Header().Set("Content-Type", entry.Type)
+	http.ServeContent(w, r, "", entry.Created, audio)
+}
+
+// resolveCredentials falls back to the credentials of the api key, or the
+// server ones, for the ones not given in the request.
+func resolveCredentials(ctx context.Context, key, region string) (string, string, error) {
+	if !allowClientCredentials && (key != "" || region != "") {
+		return "", "", fieldError("credentials_not_allowed", "azureKey", "azureKey and azureRegion can't be set in the request")
+	}
+
+	if region != "" && !regionPattern.MatchString(region) {
+		return "", "", fieldError("invalid_region", "azureRegion", "azureRegion must be the name of an azure region, e.g. westeurope")
+	}
+
+	// the credentials of the server are only sent to its own regions
+	if key == "" {
+		if tenant, ok := tenantCredentials[clientName(ctx)]; ok {
+			if region != "" && region != tenant.Region {
+				return "", "", fieldError("region_not_allowed", "azureRegion", "azureRegion can only be set together with azureKey")
+			}
+			key, region = tenant.Key, tenant.Region
+		} else if target, ok := failoverTarget(region); ok && region != azureRegion {
+			key = target.Key
+		} else if region == "" || region == azureRegion {
+			key = serverKey()
+		} else {
+			return "", "", fieldError("region_not_allowed", "azureRegion", "azureRegion can only be set together with azureKey")
+		}
+	}
+	if region == "" {
+		region = azureRegion
+	}
+
+	if key == "" && azureAuth != "aad" {
+		return "", "", fieldError("missing_field", "azureKey", "azureKey is required")
+	}
+	if region == "" {
+		return "", "", fieldError("missing_field", "azureRegion", "azureRegion is required")
+	}
+	return key, region, nil
+}
+
+func ttsRequestFromQuery(query url.Values) TTSRequest {
+	// invalid json is ignored like other invalid parameters
+	var phonemes map[string]string
+	if value := query.Get("phonemes"); value != "" {
+		json.Unmarshal([]byte(value), &phonemes)
+	}
+	var segments []Segment
+	if value := query.Get("segments"); value != "" {
+		json.Unmarshal([]byte(value), &segments)
+	}
+	var ttlSeconds *int
+	if value, err := strconv.Atoi(query.Get("ttlSeconds")); err == nil {
+		ttlSeconds = &value
+	}
+
+	return TTSRequest{
+		Text:            query.Get("text"),
+		SSML:            query.Get("ssml"),
+		Language:        query.Get("language"),
+		Gender:          query.Get("gender"),
+		Name:            query.Get("name"),
+		Style:           query.Get("style"),
+		StyleDegree:     query.Get("styleDegree"),
+		Role:            query.Get("role"),
+		Rate:            query.Get("rate"),
+		Pitch:           query.Get("pitch"),
+		Volume:          query.Get("volume"),
+		AzureKey:        query.Get("azureKey"),
+		AzureRegion:     query.Get("azureRegion"),
+		ShouldCache:     query.Get("shouldCache") == "true",
+		AllowMarkup:     query.Get("allowMarkup") == "true",
+		Split:           query.Get("split") == "true",
+		DeploymentID:    query.Get("deploymentId"),
+		LexiconURL:      query.Get("lexiconUrl"),
+		Phonemes:        phonemes,
+		PhonemeAlphabet: query.Get("phonemeAlphabet"),
+		Segments:        segments,
+		LeadingPause:    queryInt(query, "leadingPauseMs"),
+		TrailingPause:   queryInt(query, "trailingPauseMs"),
+		Provider:        query.Get("provider"),
+		ForceRefresh:    query.Get("forceRefresh") == "true",
+		TTLSeconds:      ttlSeconds,
+		Namespace:       query.Get("namespace"),
+		Format:          query.Get("format"),
+	}
+}
+
+func queryInt(query url.Values, name string) int {
+	value, _ := strconv.Atoi(query.Get(name))
+	return value
+}
+
+// genders are the values of the gender of a voice
+var genders = []string{"Male", "Female", "Neutral"}
+
+// applyDefaults fills in the defaults that are part of the cache key: the
+// namespace of the client, the provider, the lexicon and the phoneme alphabet.
+func applyDefaults(ctx context.Context, ttsRequest *TTSRequest) error {
+	namespace, err := resolveNamespace(ctx, ttsRequest.Namespace)
+	if err != nil {
+		return err
+	}
+	ttsRequest.Namespace = namespace
+
+	if ttsRequest.Provider == "" {
+		ttsRequest.Provider = defaultProvider
+	}
+	if _, ok := providers[ttsRequest.Provider]; !ok {
+		return fieldError("invalid_provider", "provider", "provider %s is unknown or not configured", ttsRequest.Provider)
+	}
+
+	if ttsRequest.LexiconURL == "" && ttsRequest.SSML == "" && ttsRequest.Provider == "azure" {
+		ttsRequest.LexiconURL = defaultLexicons[strings.ToLower(ttsRequest.Language)]
+	}
+
+	if len(ttsRequest.Phonemes) == 0 {
+		ttsRequest.PhonemeAlphabet = ""
+	} else if ttsRequest.PhonemeAlphabet == "" {
+		ttsRequest.PhonemeAlphabet = "ipa"
+	}
+	return nil
+}
+
+// prepareRequest fills in the defaults, the server credentials and the
+// namespace of the client and validates the request.
+func prepareRequest(ctx context.Context, ttsRequest *TTSRequest) error {
+	if err := applyDefaults(ctx, ttsRequest); err != nil {
+		return err
+	}
+	provider := providers[ttsRequest.Provider]
+
+	if ttsRequest.Provider == "azure" {
+		var err error
+		ttsRequest.AzureKey, ttsRequest.AzureRegion, err = resolveCredentials(ctx, ttsRequest.AzureKey, ttsRequest.AzureRegion)
+		if err != nil {
+			return err
+		}
+	} else {
+		ttsRequest.AzureKey, ttsRequest.AzureRegion = "", ""
+	}
+
+	if ttsRequest.Text != "" && ttsRequest.SSML != "" {
+		return fieldError("conflicting_fields", "ssml", "text and ssml can't be used together")
+	}
+
+	if len(ttsRequest.Segments) > 0 {
+		if ttsRequest.Text != "" || ttsRequest.SSML != "" {
+			return fieldError("conflicting_fields", "segments", "segments can't be used together with text or ssml")
+		}
+		if ttsRequest.Split || ttsRequest.AllowMarkup {
+			return fieldError("conflicting_fields", "segments", "segments can't be used with split or allowMarkup")
+		}
+		for _, segment := range ttsRequest.Segments {
+			if segment.Text == "" {
+				return fieldError("missing_field", "segments", "every segment needs text")
+			}
+		}
+	} else if ttsRequest.Text == "" && ttsRequest.SSML == "" {
+		return fieldError("missing_field", "text", "text, ssml or segments is required")
+	}
+	if maxTextLength > 0 && textLength(*ttsRequest) > maxTextLength {
+		return fieldError("text_too_long", "text", "text is too long, at most %d characters are allowed", maxTextLength)
+	}
+
+	if ttsRequest.Gender != "" && !slices.ContainsFunc(genders, func(g string) bool { return strings.EqualFold(g, ttsRequest.Gender) }) {
+		return fieldError("invalid_gender", "gender", "gender must be one of %s", strings.Join(genders, ", "))
+	}
+
+	if ttsRequest.LexiconURL != "" {
+		if u, err := url.Parse(ttsRequest.LexiconURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fieldError("invalid_lexicon_url", "lexiconUrl", "lexiconUrl must be an http or https url")
+		}
+	}
+
+	if len(ttsRequest.Phonemes) > 0 {
+		if ttsRequest.SSML != "" || ttsRequest.AllowMarkup {
+			return fieldError("conflicting_fields", "phonemes", "phonemes can only be used with plain text")
+		}
+		if !slices.Contains(phonemeAlphabets, ttsRequest.PhonemeAlphabet) {
+			return fieldError("invalid_phoneme_alphabet", "phonemeAlphabet", "phonemeAlphabet must be one of %s", strings.Join(phonemeAlphabets, ", "))
+		}
+	}
+
+	if ttsRequest.TTLSeconds != nil {
+		if *ttsRequest.TTLSeconds < 0 {
+			return fieldError("invalid_ttl", "ttlSeconds", "ttlSeconds can't be negative")
+		}
+		ttsRequest.ShouldCache = true
+	}
+
+	for field, pause := range map[string]int{"leadingPauseMs": ttsRequest.LeadingPause, "trailingPauseMs": ttsRequest.TrailingPause} {
+		if pause < 0 || pause > maxPause {
+			return fieldError("invalid_pause", field, "pauses must be between 0 and %d ms", maxPause)
+		}
+	}
+
+	if ttsRequest.StyleDegree != "" {
+		degree, err := strconv.ParseFloat(ttsRequest.StyleDegree, 64)
+		if err != nil || degree < 0.01 || degree > 2 {
+			return fieldError("invalid_style_degree", "styleDegree", "styleDegree must be a number between 0.01 and 2")
+		}
+	}
+
+	if err := validateFormat(ttsRequest); err != nil {
+		return err
+	}
+
+	if ttsRequest.Split && (ttsRequest.SSML != "" || ttsRequest.AllowMarkup) {
+		return fieldError("conflicting_fields", "split", "split can only be used with plain text")
+	}
+
+	if ttsRequest.AllowMarkup && !allowMarkup {
+		return fieldError("markup_disabled", "allowMarkup", "allowMarkup is not enabled on this server")
+	}
+
+	if ttsRequest.SSML != "" || ttsRequest.AllowMarkup {
+		if err := validateSSML(buildSSML(*ttsRequest)); err != nil {
+			return fieldError("invalid_ssml", "ssml", "invalid ssml: %s", err)
+		}
+	}
+
+	return provider.Validate(*ttsRequest)
+}
+
+// limitError is returned when a request is over a rate limit or quota.
+type limitError struct {
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+// synthesisAllowed applies the character limits and voice validation
+// to a request that is about to be sent to Azure.
+func synthesisAllowed(ctx context.Context, limitID string, ttsRequest TTSRequest, chars int64) error {
+	// only text that is sent to azure counts towards the character limits
+	if ok, retryAfter := charLimiter.take(limitID, float64(chars)); !ok {
+		return &limitError{code: "rate_limited", message: "rate limit exceeded", retryAfter: retryAfter}
+	}
+
+	if err := validateVoice(ttsRequest); err != nil {
+		return err
+	}
+
+	// the quota is checked last, the local fallback can serve requests over it
+	if usage.quotaExceeded(clientName(ctx), chars) {
+		return &limitError{code: "quota_exceeded", message: "monthly character quota exceeded", retryAfter: untilNextMonth()}
+	}
+	return nil
+}
+
+// checkSynthesisAllowed is synthesisAllowed for http requests, responding
+// with an error if the request isn't allowed.
+func checkSynthesisAllowed(w http.ResponseWriter, r *http.Request, ttsRequest TTSRequest, chars int64) bool {
+	return checkAllowed(w, synthesisAllowed(r.Context(), rateLimitID(r), ttsRequest, chars))
+}
+
+// checkAllowed responds with the error of synthesisAllowed, it
+// reports whether the request can be synthesized.
+func checkAllowed(w http.ResponseWriter, err error) bool {
+	var limitErr *limitError
+	if errors.As(err, &limitErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.retryAfter.Seconds()))))
+		writeError(w, &apiError{Code: limitErr.code, Message: limitErr.message}, http.StatusTooManyRequests)
+		return false
+	}
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func handleTTSRequest(w http.ResponseWriter, r *http.Request) {
+	var ttsRequest TTSRequest
+	_, span := tracer.Start(r.Context(), "decode")
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		query := r.URL.Query()
+		if err := verifySignature(query); err != nil {
+			endSpan(span, err)
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+		ttsRequest = ttsRequestFromQuery(query)
+	} else {
+		err := readJSON(w, r, &ttsRequest)
+		if err != nil {
+			endSpan(span, err)
+			bodyError(w, err)
+			return
+		}
+	}
+	endSpan(span, nil)
+
+	if r.Method == http.MethodHead {
+		headTTS(w, r, ttsRequest)
+		return
+	}
+	serveTTS(w, r, ttsRequest)
+}
+
+// serveTTS responds with the audio of the request from the cache or from azure.
+func serveTTS(w http.ResponseWriter, r *http.Request, ttsRequest TTSRequest) {
+	if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if ttsRequest.Split {
+		handleSplitRequest(w, r, ttsRequest)
+		return
+	}
+
+	key := cacheKey(ttsRequest)
+	info := requestInfoFrom(r.Context())
+	info.Voice = ttsRequest.Name
+	// the key only depends on the request, so clients can use it to refer to the
+	// entry in the admin api and identical requests of other clients share it
+	w.Header().Set("X-Cache-Key", key)
+
+	_, span := tracer.Start(r.Context(), "cache.lookup")
+	value, temp, ok := cachedEntry(key)
+	info.Cache = "hit"
+	if temp {
+		info.Cache = "temp-hit"
+	}
+	span.End()
+	if ttsRequest.ForceRefresh {
+		ok = false
+	}
+
+	if ok {
+		recordHit(key, value, temp)
+		setCacheHeaders(w, value.Created, info.Cache != "temp-hit")
+		setCacheControl(w, info.Cache != "temp-hit")
+		if info.Cache == "hit" && isStale(value) {
+			w.Header().Set("X-Cache", "STALE")
+			revalidate(r.Context(), ttsRequest, key)
+		}
+		if notModified(w, r, `"`+cache.Hash(value)+`"`) {
+			return
+		}
+		_, span = tracer.Start(r.Context(), "response.copy")
+		serveEntry(w, r, value)
+		span.End()
+		return
+	}
+	info.Cache = "miss"
+	counters.misses.Add(1)
+	w.Header().Set("X-Cache", "MISS")
+
+	chars := requestChars(ttsRequest)
+	err := synthesisAllowed(r.Context(), rateLimitID(r), ttsRequest, chars)
+	var limitErr *limitError
+	if errors.As(err, &limitErr) && limitErr.code == "quota_exceeded" && len(localCommand) > 0 {
+		serveFallback(w, r, ttsRequest, err)
+		return
+	}
+	if !checkAllowed(w, err) {
+		return
+	}
+
+	// concurrent requests for the same audio share a single Azure call,
+	// only the first one streams the response directly
+	streamed := false
+	val, err, _ := synthesisGroup.Do(key, func() (interface{}, error) {
+		streamed = true
+		return synthesize(r.Context(), w, ttsRequest, key)
+	})
+	if streamed && err == nil {
+		usage.add(clientName(r.Context()), chars)
+	}
+	if err != nil && !streamed && errors.Is(err, context.Canceled) && r.Context().Err() == nil {
+		// the client that started the shared call went away, try again on our own
+		val, err, _ = synthesisGroup.Do(key, func() (interface{}, error) {
+			streamed = true
+			return synthesize(r.Context(), w, ttsRequest, key)
+		})
+	}
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		if streamed && errors.Is(err, errStreamInterrupted) {
+			// the response is already partially written
+			return
+		}
+		if shouldFallback(err) {
+			serveFallback(w, r, ttsRequest, err)
+			return
+		}
+		if errors.Is(err, azure.ErrCircuitOpen) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(azure.BreakerCooldown().Seconds())))
+			writeError(w, err, http.StatusServiceUnavailable)
+			return
+		}
+		if synthesisBusy(w, err) || azureFailed(w, ttsRequest, err) || providerFailed(w, err) {
+			return
+		}
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !streamed {
+		entry := val.(cache.Entry)
+		if ttsRequest.ShouldCache {
+			storeEntry(ttsRequest, key, entry)
+		}
+		serveEntry(w, r, entry)
+	}
+}
+
+// lookupEntry returns the entry from the permanent or the temporary cache.
+func lookupEntry(key string) (cache.Entry, bool) {
+	entry, _, ok := cachedEntry(key)
+	return entry, ok
+}
+
+// cachedEntry is lookupEntry that also reports whether the entry is from
+// the temporary cache.
+func cachedEntry(key string) (entry cache.Entry, temp bool, ok bool) {
+	if entry, ok := c.Get(key); ok {
+		return entry, false, true
+	}
+	entry, ok = tempC.Get(key)
+	return entry, true, ok
+}
+
+// synthesizeInBackground synthesizes the request without a client to stream
+// the audio to, sharing the Azure call with concurrent identical requests.
+func synthesizeInBackground(ctx context.Context, ttsRequest TTSRequest, key string) (cache.Entry, error) {
+	// transcoded requests don't count the characters once their source is cached
+	chars := requestChars(ttsRequest)
+	leader := false
+	val, err, _ := synthesisGroup.Do(key, func() (interface{}, error) {
+		leader = true
+		return synthesize(ctx, discardResponseWriter{}, ttsRequest, key)
+	})
+	if err != nil {
+		return cache.Entry{}, err
+	}
+
+	entry := val.(cache.Entry)
+	if leader {
+		usage.add(clientName(ctx), chars)
+	} else if ttsRequest.ShouldCache {
+		storeEntry(ttsRequest, key, entry)
+	}
+	return entry, nil
+}
+
+// synthesize requests the audio from the provider, or transcodes it, streams
+// it to w and stores it in the cache.
+func synthesize(ctx context.Context, w http.ResponseWriter, ttsRequest TTSRequest, key string) (cache.Entry, error) {
+	if err, ok := recentFailure(ttsRequest, key); ok && !ttsRequest.ForceRefresh {
+		return cache.Entry{}, err
+	}
+
+	// the source is synthesized before taking a slot, it needs one of its own
+	var source cache.Entry
+	if transcoded(ttsRequest) {
+		var err error
+		if source, err = sourceAudio(ctx, ttsRequest); err != nil {
+			return cache.Entry{}, err
+		}
+	}
+
+	release, err := acquireSynthesisSlot(ctx)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	defer release()
+
+	var audio io.ReadCloser
+	var contentType string
+	if transcoded(ttsRequest) {
+		transcodeCtx, span := tracer.Start(ctx, "transcode")
+		audio, contentType, err = transcode(transcodeCtx, source, ttsRequest.Format)
+		endSpan(span, err)
+		if err != nil {
+			return cache.Entry{}, err
+		}
+	} else {
+		start := time.Now()
+		providerCtx, span := tracer.Start(ctx, ttsRequest.Provider+".request")
+		audio, contentType, err = providers[ttsRequest.Provider].Synthesize(providerCtx, ttsRequest)
+		endSpan(span, err)
+		if err != nil {
+			counters.providerErrors.Add(1)
+			rememberFailure(ttsRequest, key, err)
+			return cache.Entry{}, err
+		}
+		requestInfoFrom(ctx).AzureLatency = time.Since(start)
+
+		if loudnessTarget != 0 {
+			normalizeCtx, span := tracer.Start(ctx, "normalize")
+			audio, contentType, err = normalizeLoudness(normalizeCtx, audio, contentType, ttsRequest)
+			endSpan(span, err)
+			if err != nil {
+				return cache.Entry{}, err
+			}
+		}
+	}
+	defer audio.Close()
+
+	_, span := tracer.Start(ctx, "response.copy")
+	defer span.End()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Transfer-Encoding", "chunked")
+	setCacheControl(w, ttsRequest.ShouldCache)
+
+	// flush every chunk so the client can start playback while Azure is still sending
+	flusher, _ := w.(http.Flusher)
+	var buffer = &bytes.Buffer{}
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := audio.Read(chunk)
+		if n > 0 {
+			buffer.Write(chunk[:n])
+			w.Write(chunk[:n])
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Error("Failed to read audio from provider", "provider", ttsRequest.Provider, "error", err)
+			counters.providerErrors.Add(1)
+			return cache.Entry{}, fmt.Errorf("%w: %v", errStreamInterrupted, err)
+		}
+	}
+
+	if !transcoded(ttsRequest) {
+		counters.providerBytes.Add(int64(buffer.Len()))
+	}
+
+	entry := cache.Entry{
+		Audio:    buffer.Bytes(),
+		Type:     contentType,
+		Text:     requestText(ttsRequest),
+		Voice:    ttsRequest.Name,
+		Language: ttsRequest.Language,
+		Created:  time.Now(),
+	}
+	storeEntry(ttsRequest, key, entry)
+
+	return entry, nil
+}
+
+func storeEntry(ttsRequest TTSRequest, key string, entry cache.Entry) {
+	if _, ok := c.Get(key); ok {
+		if !ttsRequest.ForceRefresh {
+			return
+		}
+		// delete the old entry first so its audio blob is removed,
+		// the new one stays permanent
+		c.Delete(key)
+		ttsRequest.ShouldCache = true
+	}
+
+	entry.Namespace = ttsRequest.Namespace
+	if !ttsRequest.ShouldCache {
+		tempC.Set(key, entry, tempCacheTTL)
+		return
+	}
+	ttl := entryTTL(ttsRequest)
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+		entry.TTL = ttl
+	}
+	c.Set(key, entry, ttl)
+	cacheSet(key, entry)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// withServerCredentials configures the azure credentials of the server for
+// the test.
+func withServerCredentials(t *testing.T) {
+	t.Helper()
+	oldKey, oldRegion, oldProvider, oldAllow := azureKey, azureRegion, defaultProvider, allowClientCredentials
+	azureKey, azureRegion, defaultProvider, allowClientCredentials = "server-key", "westeurope", "azure", true
+	t.Cleanup(func() {
+		azureKey, azureRegion, defaultProvider, allowClientCredentials = oldKey, oldRegion, oldProvider, oldAllow
+	})
+}
+
+func TestCacheKey(t *testing.T) {
+	base := TTSRequest{Text: "Hello", Language: "en-US", Name: "en-US-BrianNeural"}
+	key := cacheKey(base)
+
+	if !isCacheKey(key) {
+		t.Fatalf("cacheKey returned %q, not a cache key", key)
+	}
+	if cacheKey(base) != key {
+		t.Error("cacheKey isn't deterministic")
+	}
+
+	// the credentials and caching options don't change the audio
+	same := base
+	same.AzureKey, same.AzureRegion, same.ShouldCache, same.ForceRefresh = "key", "eastus", true, true
+	if cacheKey(same) != key {
+		t.Error("credentials or caching options changed the key")
+	}
+	// azure is the default provider, so its key stays the same
+	same.Provider = "azure"
+	if cacheKey(same) != key {
+		t.Error("the azure provider changed the key")
+	}
+
+	for name, change := range map[string]func(*TTSRequest){
+		"text":      func(r *TTSRequest) { r.Text = "Hi" },
+		"voice":     func(r *TTSRequest) { r.Name = "en-US-JennyNeural" },
+		"rate":      func(r *TTSRequest) { r.Rate = "1.2" },
+		"namespace": func(r *TTSRequest) { r.Namespace = "app1" },
+		"format":    func(r *TTSRequest) { r.Format = "opus" },
+		"pause":     func(r *TTSRequest) { r.LeadingPause = 500 },
+		"phonemes":  func(r *TTSRequest) { r.Phonemes = map[string]string{"Hello": "həˈloʊ"} },
+	} {
+		changed := base
+		change(&changed)
+		if cacheKey(changed) == key {
+			t.Errorf("changing the %s didn't change the key", name)
+		}
+	}
+}
+
+func TestCacheKeyLoudness(t *testing.T) {
+	ttsRequest := TTSRequest{Text: "Hello"}
+	key := cacheKey(ttsRequest)
+	t.Cleanup(func() { loudnessTarget = 0 })
+
+	loudnessTarget = -16
+	normalized := cacheKey(ttsRequest)
+	if normalized == key {
+		t.Error("audio normalized to LOUDNESS_TARGET has the key of the audio without it")
+	}
+	loudnessTarget = -23
+	if cacheKey(ttsRequest) == normalized {
+		t.Error("changing LOUDNESS_TARGET didn't change the key")
+	}
+}
+
+func TestPrepareRequestDefaults(t *testing.T) {
+	withServerCredentials(t)
+
+	ttsRequest := TTSRequest{Text: "Hello", Phonemes: map[string]string{"Hello": "həˈloʊ"}}
+	if err := prepareRequest(context.Background(), &ttsRequest); err != nil {
+		t.Fatal(err)
+	}
+	if ttsRequest.Provider != "azure" {
+		t.Errorf("Provider = %q, want azure", ttsRequest.Provider)
+	}
+	if ttsRequest.AzureKey != "server-key" || ttsRequest.AzureRegion != "westeurope" {
+		t.Errorf("credentials = %q, %q, want the server ones", ttsRequest.AzureKey, ttsRequest.AzureRegion)
+	}
+	if ttsRequest.PhonemeAlphabet != "ipa" {
+		t.Errorf("PhonemeAlphabet = %q, want ipa", ttsRequest.PhonemeAlphabet)
+	}
+}
+
+func TestPrepareRequestRejects(t *testing.T) {
+	withServerCredentials(t)
+
+	negative := -1
+	for name, ttsRequest := range map[string]TTSRequest{
+		"no text":             {},
+		"text and ssml":       {Text: "Hello", SSML: "<speak/>"},
+		"invalid gender":      {Text: "Hello", Gender: "Robot"},
+		"negative ttl":        {Text: "Hello", TTLSeconds: &negative},
+		"negative pause":      {Text: "Hello", LeadingPause: -1},
+		"style degree":        {Text: "Hello", StyleDegree: "3"},
+		"unknown format":      {Text: "Hello", Format: "flac"},
+		"unknown provider":    {Text: "Hello", Provider: "polly"},
+		"region host":         {Text: "Hello", AzureRegion: "evil.example/?"},
+		"other region":        {Text: "Hello", AzureRegion: "eastus"},
+		"split with markup":   {Text: "Hello", Split: true, AllowMarkup: true},
+		"segments with text":  {Text: "Hello", Segments: []Segment{{Text: "Hi"}}},
+		"lexicon not on http": {Text: "Hello", LexiconURL: "file:///etc/passwd"},
+	} {
+		if err := prepareRequest(context.Background(), &ttsRequest); err == nil {
+			t.Errorf("%s: prepareRequest accepted the request", name)
+		}
+	}
+}
+
+func TestPrepareRequestClientRegion(t *testing.T) {
+	withServerCredentials(t)
+
+	// the client can use its own key in any region
+	ttsRequest := TTSRequest{Text: "Hello", AzureKey: "client-key", AzureRegion: "eastus"}
+	if err := prepareRequest(context.Background(), &ttsRequest); err != nil {
+		t.Fatal(err)
+	}
+	if ttsRequest.AzureKey != "client-key" || ttsRequest.AzureRegion != "eastus" {
+		t.Errorf("credentials = %q, %q, want the ones of the request", ttsRequest.AzureKey, ttsRequest.AzureRegion)
+	}
+}
+
+func TestPrepareRequestTenantNamespace(t *testing.T) {
+	withServerCredentials(t)
+	tenantNamespaces = true
+	t.Cleanup(func() { tenantNamespaces = false })
+
+	ctx := withTenant(context.Background(), "app1")
+	ttsRequest := TTSRequest{Text: "Hello", Namespace: "greetings"}
+	if err := prepareRequest(ctx, &ttsRequest); err != nil {
+		t.Fatal(err)
+	}
+	if ttsRequest.Namespace != "app1/greetings" {
+		t.Errorf("Namespace = %q, want app1/greetings", ttsRequest.Namespace)
+	}
+}
+
+func TestDeleteCacheQueryWithoutCredentials(t *testing.T) {
+	oldKey, oldProvider, oldCache, oldTemp := azureKey, defaultProvider, c, tempC
+	azureKey, defaultProvider = "", "azure"
+	c, tempC = cache.NewMemory(0, time.Minute), cache.NewMemory(0, time.Minute)
+	t.Cleanup(func() { azureKey, defaultProvider, c, tempC = oldKey, oldProvider, oldCache, oldTemp })
+
+	// an entry a tenant cached in its namespace
+	ttsRequest := TTSRequest{Text: "Hello", Namespace: "app1"}
+	if err := applyDefaults(context.Background(), &ttsRequest); err != nil {
+		t.Fatal(err)
+	}
+	key := cacheKey(ttsRequest)
+	c.Set(key, cache.Entry{Audio: []byte("audio")}, 0)
+
+	r := httptest.NewRequest(http.MethodDelete, "/cache?text=Hello&namespace=app1", nil)
+	w := httptest.NewRecorder()
+	handleDeleteCacheQuery(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %s", w.Code, w.Body)
+	}
+	if _, ok := c.Get(key); ok {
+		t.Error("entry still cached")
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var signingSecret string
+
+// verifySignature checks signed GET urls when SIGNING_SECRET is set.
+// The signature is a hex encoded HMAC-SHA256 of the encoded query
+// (sorted by key, without the signature parameter) and the url must
+// contain an expires unix timestamp.
+func verifySignature(query url.Values) error {
+	if signingSecret == "" {
+		return nil
+	}
+
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || len(signature) == 0 {
+		return errors.New("signature is required")
+	}
+
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("expires is required")
+	}
+	if time.Now().Unix() > expires {
+		return errors.New("url has expired")
+	}
+
+	if !hmac.Equal(signature, sign(query)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// signedQuery returns the query of the request the signature is for.
+func signedQuery(r *http.Request) url.Values {
+	if r.URL.Path == "/tts.twiml" {
+		return withoutCallParams(r.URL.Query())
+	}
+	return r.URL.Query()
+}
+
+func sign(query url.Values) []byte {
+	unsigned := url.Values{}
+	for key, values := range query {
+		if key != "signature" {
+			unsigned[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(unsigned.Encode()))
+	return mac.Sum(nil)
+}
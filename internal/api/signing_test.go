@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedTestQuery(expires time.Time) url.Values {
+	query := url.Values{"text": {"Hello"}, "expires": {strconv.FormatInt(expires.Unix(), 10)}}
+	query.Set("signature", hex.EncodeToString(sign(query)))
+	return query
+}
+
+func TestVerifySignature(t *testing.T) {
+	signingSecret = "secret"
+	t.Cleanup(func() { signingSecret = "" })
+
+	if err := verifySignature(signedTestQuery(time.Now().Add(time.Minute))); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+
+	expired := signedTestQuery(time.Now().Add(-time.Minute))
+	if err := verifySignature(expired); err == nil {
+		t.Error("expired url accepted")
+	}
+
+	tampered := signedTestQuery(time.Now().Add(time.Minute))
+	tampered.Set("text", "Goodbye")
+	if err := verifySignature(tampered); err == nil {
+		t.Error("tampered url accepted")
+	}
+
+	unsigned := signedTestQuery(time.Now().Add(time.Minute))
+	unsigned.Del("signature")
+	if err := verifySignature(unsigned); err == nil {
+		t.Error("url without signature accepted")
+	}
+
+	otherSecret := signedTestQuery(time.Now().Add(time.Minute))
+	signingSecret = "other"
+	if err := verifySignature(otherSecret); err == nil {
+		t.Error("url signed with another secret accepted")
+	}
+}
+
+func TestVerifySignatureDisabled(t *testing.T) {
+	signingSecret = ""
+	if err := verifySignature(url.Values{"text": {"Hello"}}); err != nil {
+		t.Errorf("unsigned url rejected without SIGNING_SECRET: %v", err)
+	}
+}
+
+func TestTwiMLRequiresSigningWithAPIKeys(t *testing.T) {
+	oldKeys := apiKeys
+	apiKeys, signingSecret = map[string]string{"key": "app1"}, ""
+	t.Cleanup(func() { apiKeys = oldKeys })
+
+	w := httptest.NewRecorder()
+	handleTwiML(w, httptest.NewRequest(http.MethodGet, "/tts.twiml?text=Hello", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("status = %d, want 501 for audio urls twilio can't fetch", w.Code)
+	}
+}
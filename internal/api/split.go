@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+var splitMaxChars = 1000
+var splitConcurrency = 4
+
+type splitResult struct {
+	entry cache.Entry
+	err   error
+}
+
+// splitSentences splits text into sentences, breaking up the ones
+// longer than maxChars at whitespace.
+func splitSentences(text string, maxChars int) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+	for i, r := range runes {
+		end := r == '\n' || ((r == '.' || r == '!' || r == '?' || r == '…') && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])))
+		if end || i+1 == len(runes) {
+			if sentence := strings.TrimSpace(string(runes[start : i+1])); sentence != "" {
+				sentences = append(sentences, splitLong(sentence, maxChars)...)
+			}
+			start = i + 1
+		}
+	}
+	return sentences
+}
+
+func splitLong(sentence string, maxChars int) []string {
+	var parts []string
+	runes := []rune(sentence)
+	for len(runes) > maxChars {
+		cut := maxChars
+		for i := maxChars; i > maxChars/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+		parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+		runes = []rune(strings.TrimSpace(string(runes[cut:])))
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// splitParts returns a request per sentence of a split request.
+func splitParts(ttsRequest TTSRequest) []TTSRequest {
+	var parts []TTSRequest
+	sentences := splitSentences(ttsRequest.Text, splitMaxChars)
+	for i, sentence := range sentences {
+		part := ttsRequest
+		part.Text = sentence
+		part.Split = false
+		// the pauses are only before the first and after the last sentence
+		if i > 0 {
+			part.LeadingPause = 0
+		}
+		if i < len(sentences)-1 {
+			part.TrailingPause = 0
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// handleSplitRequest synthesizes every sentence of the text as a separate
+// cache entry and streams the concatenated audio, so long text stays under
+// the Azure limits and edits only synthesize the sentences that changed.
+func handleSplitRequest(w http.ResponseWriter, r *http.Request, ttsRequest TTSRequest) {
+	parts := splitParts(ttsRequest)
+	var missing int64
+	for _, part := range parts {
+		if _, ok := lookupEntry(cacheKey(part)); !ok || part.ForceRefresh {
+			missing += int64(len([]rune(part.Text)))
+		}
+	}
+
+	info := requestInfoFrom(r.Context())
+	info.Voice = ttsRequest.Name
+	info.Cache = "split"
+
+	if missing > 0 && !checkSynthesisAllowed(w, r, ttsRequest, missing) {
+		return
+	}
+
+	// sentences are synthesized concurrently but written in order
+	results := make([]chan splitResult, len(parts))
+	for i := range results {
+		results[i] = make(chan splitResult, 1)
+	}
+	go func() {
+		slots := make(chan struct{}, max(splitConcurrency, 1))
+		for i, part := range parts {
+			slots <- struct{}{}
+			go func() {
+				defer func() { <-slots }()
+				// every sentence gets its own request info, the azure latency
+				// of the parts would race otherwise
+				ctx := context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{})
+				entry, err := synthesizePart(ctx, part)
+				results[i] <- splitResult{entry, err}
+			}()
+		}
+	}()
+
+	written := false
+	for _, result := range results {
+		res := <-result
+		if res.err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			if written {
+				// the audio is already partially written, abort the connection
+				// so the client doesn't mistake it for the complete audio
+				slog.Error("Failed to synthesize sentence", "error", res.err)
+				panic(http.ErrAbortHandler)
+			}
+			if errors.Is(res.err, azure.ErrCircuitOpen) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(azure.BreakerCooldown().Seconds())))
+				writeError(w, res.err, http.StatusServiceUnavailable)
+				return
+			}
+			if synthesisBusy(w, res.err) || azureFailed(w, ttsRequest, res.err) || providerFailed(w, res.err) {
+				return
+			}
+			writeError(w, res.err, http.StatusInternalServerError)
+			return
+		}
+
+		audio, err := openEntry(res.entry)
+		if err != nil {
+			if written {
+				slog.Error("Failed to open cached sentence", "error", err)
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !written {
+			w.Header().Set("Content-Type", res.entry.Type)
+			w.Header().Set("Transfer-Encoding", "chunked")
+			written = true
+		}
+		_, err = io.Copy(w, audio)
+		audio.Close()
+		if err != nil {
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// synthesizePart returns a single sentence from the cache or synthesizes it.
+func synthesizePart(ctx context.Context, part TTSRequest) (cache.Entry, error) {
+	key := cacheKey(part)
+	if entry, temp, ok := cachedEntry(key); ok && !part.ForceRefresh {
+		recordHit(key, entry, temp)
+		return entry, nil
+	}
+	return synthesizeInBackground(ctx, part, key)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const defaultRate = "0.8"
+
+// defaultLexicons are used for requests in the language without a lexiconUrl
+var defaultLexicons = map[string]string{}
+
+func parseLexicons(value string) (map[string]string, error) {
+	lexicons := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		language, uri, ok := strings.Cut(item, "=")
+		if !ok || language == "" || uri == "" {
+			return nil, fmt.Errorf("expected language=url, got %q", item)
+		}
+		lexicons[strings.ToLower(language)] = uri
+	}
+	return lexicons, nil
+}
+
+func buildSSML(r TTSRequest) string {
+	if r.SSML != "" {
+		return r.SSML
+	}
+
+	rate := r.Rate
+	if rate == "" {
+		rate = defaultRate
+	}
+	prosody := fmt.Sprintf("rate='%s'", escapeXML(rate))
+	if r.Pitch != "" {
+		prosody += fmt.Sprintf(" pitch='%s'", escapeXML(r.Pitch))
+	}
+	if r.Volume != "" {
+		prosody += fmt.Sprintf(" volume='%s'", escapeXML(r.Volume))
+	}
+
+	text := escapeXML(r.Text)
+	if len(r.Phonemes) > 0 {
+		text = applyPhonemes(r.Text, r.Phonemes, r.PhonemeAlphabet)
+	}
+	if r.AllowMarkup {
+		text = r.Text
+	}
+	text = addPauses(text, r.LeadingPause, r.TrailingPause)
+
+	lexicon := ""
+	if r.LexiconURL != "" {
+		lexicon = fmt.Sprintf("\n          <lexicon uri='%s'/>", escapeXML(r.LexiconURL))
+	}
+
+	if len(r.Segments) > 0 {
+		return buildDialogueSSML(r, lexicon, prosody)
+	}
+
+	if r.StyleDegree != "" || r.Role != "" {
+		return buildExpressAsSSML(r, lexicon, prosody, text)
+	}
+
+	return fmt.Sprintf(`
+      <speak version='1.0' xml:lang='en-US'>
+        <voice xml:lang='%s' xml:gender='%s' name='%s' style='%s'>%s
+          <prosody %s>
+            %s
+          </prosody>
+        </voice>
+      </speak>	
+	`, escapeXML(r.Language), escapeXML(r.Gender), escapeXML(r.Name), escapeXML(r.Style), lexicon, prosody, text)
+}
+
+// buildExpressAsSSML wraps the text in mstts:express-as, which is needed for
+// the style degree and role.
+func buildExpressAsSSML(r TTSRequest, lexicon, prosody, text string) string {
+	expressAs := ""
+	if r.Style != "" {
+		expressAs += fmt.Sprintf(" style='%s'", escapeXML(r.Style))
+	}
+	if r.StyleDegree != "" {
+		expressAs += fmt.Sprintf(" styledegree='%s'", escapeXML(r.StyleDegree))
+	}
+	if r.Role != "" {
+		expressAs += fmt.Sprintf(" role='%s'", escapeXML(r.Role))
+	}
+
+	return fmt.Sprintf(`
+      <speak version='1.0' xmlns='http://www.w3.org/2001/10/synthesis' xmlns:mstts='https://www.w3.org/2001/mstts' xml:lang='en-US'>
+        <voice xml:lang='%s' xml:gender='%s' name='%s'>%s
+          <mstts:express-as%s>
+            <prosody %s>
+              %s
+            </prosody>
+          </mstts:express-as>
+        </voice>
+      </speak>
+	`, escapeXML(r.Language), escapeXML(r.Gender), escapeXML(r.Name), lexicon, expressAs, prosody, text)
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// validateSSML checks that the document is well-formed xml with a speak root element.
+func validateSSML(doc string) error {
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	root := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok && root == "" {
+			root = start.Name.Local
+		}
+	}
+
+	if root != "speak" {
+		return errors.New("root element must be <speak>")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// softTTL is the age after which cached audio is still served, but
+// synthesized again in the background, 0 disables it
+var softTTL time.Duration
+
+// refreshing has the keys that are being refreshed in the background
+var refreshing sync.Map
+
+// isStale reports whether the permanent entry should be refreshed.
+func isStale(entry cache.Entry) bool {
+	return softTTL > 0 && time.Since(entry.Created) > softTTL
+}
+
+// revalidate refreshes a stale entry in the background, the client is
+// served the stale audio in the meantime.
+func revalidate(ctx context.Context, ttsRequest TTSRequest, key string) {
+	if _, loaded := refreshing.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	// the refresh outlives the request that triggered it
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer refreshing.Delete(key)
+		if _, err := refreshEntry(ctx, ttsRequest, key); err != nil {
+			slog.Warn("Failed to refresh stale entry", "key", key, "error", err)
+		}
+	}()
+}
+
+// refreshEntry synthesizes the request again and replaces the cached entry.
+func refreshEntry(ctx context.Context, ttsRequest TTSRequest, key string) (cache.Entry, error) {
+	ttsRequest.ForceRefresh = true
+	return synthesizeInBackground(ctx, ttsRequest, key)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// cacheCounters counts how audio requests were served since the process started
+type cacheCounters struct {
+	hits           atomic.Int64
+	tempHits       atomic.Int64
+	misses         atomic.Int64
+	providerErrors atomic.Int64
+	cacheBytes     atomic.Int64
+	providerBytes  atomic.Int64
+}
+
+var counters cacheCounters
+var startTime = time.Now()
+
+func (s *cacheCounters) hit(key string, entry cache.Entry, temp bool) {
+	if temp {
+		s.tempHits.Add(1)
+	} else {
+		s.hits.Add(1)
+	}
+	s.cacheBytes.Add(cache.Size(key, entry) - int64(len(key)))
+}
+
+// recordHit counts a request served from the cache and extends the ttl of
+// its entry, temp is set for entries of the temporary cache.
+func recordHit(key string, entry cache.Entry, temp bool) {
+	hits.add(key)
+	counters.hit(key, entry, temp)
+	touchEntry(key, entry)
+}
+
+func (s *cacheCounters) snapshot() map[string]interface{} {
+	hits := s.hits.Load()
+	tempHits := s.tempHits.Load()
+	misses := s.misses.Load()
+
+	hitRate := 0.0
+	if total := hits + tempHits + misses; total > 0 {
+		hitRate = float64(hits+tempHits) / float64(total)
+	}
+
+	return map[string]interface{}{
+		"hits":              hits,
+		"tempHits":          tempHits,
+		"misses":            misses,
+		"hitRate":           hitRate,
+		"providerErrors":    s.providerErrors.Load(),
+		"bytesFromCache":    s.cacheBytes.Load(),
+		"bytesFromProvider": s.providerBytes.Load(),
+		"uptime":            time.Since(startTime).Round(time.Second).String(),
+	}
+}
+
+// handleTopEntries lists the most served cache entries, so they can be
+// warmed or kept while the rarely used ones expire. With namespace only the
+// entries of that namespace are listed.
+func handleTopEntries(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = 50
+	}
+	n = min(n, 1000)
+	namespace, filterNamespace := r.URL.Query().Get("namespace"), r.URL.Query().Has("namespace")
+
+	accesses := hits.all()
+	keys := make([]string, 0, len(accesses))
+	for key := range accesses {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := accesses[keys[i]], accesses[keys[j]]
+		if a.Hits != b.Hits {
+			return a.Hits > b.Hits
+		}
+		return keys[i] < keys[j]
+	})
+
+	items := []cacheListItem{}
+	for _, key := range keys {
+		if len(items) == n {
+			break
+		}
+		entry, permanent := c.Get(key)
+		if !permanent {
+			var ok bool
+			if entry, ok = tempC.Get(key); !ok {
+				continue
+			}
+		}
+		if filterNamespace && entry.Namespace != namespace {
+			continue
+		}
+		access := accesses[key]
+		items = append(items, cacheListItem{
+			Key:          key,
+			Text:         snippet(entry.Text, 80),
+			Voice:        entry.Voice,
+			Namespace:    entry.Namespace,
+			Size:         cache.Size(key, entry) - int64(len(key)),
+			ContentType:  entry.Type,
+			CreatedAt:    entry.Created,
+			Hits:         access.Hits,
+			LastAccessed: &access.LastAccessed,
+			Permanent:    permanent,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+	})
+}
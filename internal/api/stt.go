@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// azure only accepts up to 60 seconds of audio for short recognitions
+const maxSTTBytes = 10 << 20
+
+// transcripts are cached apart from the audio, by the key of sttCacheKey
+var transcriptsC = gocache.New(24*time.Hour, time.Hour)
+
+func sttCacheKey(audio []byte, language, format string) string {
+	sum := sha256.Sum256(audio)
+	h := sha256.New()
+	for _, part := range []string{"stt", hex.EncodeToString(sum[:]), language, format} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleSTTRequest forwards the audio in the body to azure speech recognition
+// and caches the transcript by the hash of the audio and the language.
+func handleSTTRequest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, region, err := resolveCredentials(r.Context(), query.Get("azureKey"), query.Get("azureRegion"))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	language := query.Get("language")
+	if language == "" {
+		httpError(w, "language is required", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "simple"
+	}
+	if format != "simple" && format != "detailed" {
+		httpError(w, "format must be simple or detailed", http.StatusBadRequest)
+		return
+	}
+
+	audio, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSTTBytes))
+	if err != nil {
+		writeError(w, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(audio) == 0 {
+		httpError(w, "audio is required", http.StatusBadRequest)
+		return
+	}
+
+	transcriptKey := sttCacheKey(audio, language, format)
+	info := requestInfoFrom(r.Context())
+	info.Cache = "hit"
+	if transcript, ok := transcriptsC.Get(transcriptKey); ok {
+		serveTranscript(w, transcript.([]byte))
+		return
+	}
+	info.Cache = "miss"
+
+	if !checkAllowed(w, recognitionAllowed(r.Context(), rateLimitID(r))) {
+		return
+	}
+
+	start := time.Now()
+	transcript, text, err := recognize(r, key, region, language, format, audio)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		writeError(w, err, http.StatusBadGateway)
+		return
+	}
+	info.AzureLatency = time.Since(start)
+
+	// the characters of the transcript count towards the limits of the client
+	chars := int64(utf8.RuneCountInString(text))
+	charLimiter.charge(rateLimitID(r), float64(chars))
+	usage.add(clientName(r.Context()), chars)
+
+	// only successful recognitions are cached
+	if text != "" {
+		transcriptsC.Set(transcriptKey, transcript, gocache.DefaultExpiration)
+	}
+	serveTranscript(w, transcript)
+}
+
+// recognitionAllowed rejects the recognitions of clients over their
+// character limits, the transcript is only counted once it's known.
+func recognitionAllowed(ctx context.Context, limitID string) error {
+	if ok, retryAfter := charLimiter.take(limitID, 0); !ok {
+		return &limitError{code: "rate_limited", message: "rate limit exceeded", retryAfter: retryAfter}
+	}
+	if usage.quotaExceeded(clientName(ctx), 1) {
+		return &limitError{code: "quota_exceeded", message: "monthly character quota exceeded", retryAfter: untilNextMonth()}
+	}
+	return nil
+}
+
+func serveTranscript(w http.ResponseWriter, transcript []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(transcript)
+}
+
+func recognize(r *http.Request, key, region, language, format string, audio []byte) ([]byte, string, error) {
+	recognitionURL := fmt.Sprintf("%s/speech/recognition/conversation/cognitiveservices/v1?%s",
+		azure.RegionEndpoint(azure.Cloud.STT, region), url.Values{"language": {language}, "format": {format}}.Encode())
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, recognitionURL, bytes.NewReader(audio))
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "audio/wav; codecs=audio/pcm; samplerate=16000"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := azure.SetAuth(r.Context(), req.Header, region, key); err != nil {
+		return nil, "", err
+	}
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", azure.ResponseError(resp)
+	}
+
+	transcript, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var result struct {
+		RecognitionStatus string
+		DisplayText       string
+		NBest             []struct {
+			Display string
+		}
+	}
+	if err := json.Unmarshal(transcript, &result); err != nil {
+		return nil, "", errors.New("invalid response from azure: " + err.Error())
+	}
+	if result.RecognitionStatus != "Success" {
+		return transcript, "", nil
+	}
+
+	text := result.DisplayText
+	if text == "" && len(result.NBest) > 0 {
+		text = result.NBest[0].Display
+	}
+	return transcript, text, nil
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// twimlURLExpiry is how long the signed audio urls of /tts.twiml are valid
+const twimlURLExpiry = time.Hour
+
+// azureFormats are the formats azure can synthesize directly, so they
+// don't have to be transcoded
+var azureFormats = map[string]string{
+	"mulaw": "riff-8khz-8bit-mono-mulaw",
+	"alaw":  "riff-8khz-8bit-mono-alaw",
+}
+
+// nativeFormat reports whether the provider synthesizes the format of the
+// request itself.
+func nativeFormat(ttsRequest TTSRequest) bool {
+	_, ok := azureFormats[ttsRequest.Format]
+	return ok && ttsRequest.Provider == "azure"
+}
+
+// azureOutputFormat is the X-Microsoft-OutputFormat of the request.
+func azureOutputFormat(ttsRequest TTSRequest) string {
+	if format, ok := azureFormats[ttsRequest.Format]; ok {
+		return format
+	}
+	return outputFormat
+}
+
+// withoutCallParams removes the parameters of the call twilio adds to the
+// url, they all start with an upper case letter.
+func withoutCallParams(query url.Values) url.Values {
+	for key := range query {
+		if key != "" && unicode.IsUpper([]rune(key)[0]) {
+			query.Del(key)
+		}
+	}
+	return query
+}
+
+type twimlResponse struct {
+	XMLName xml.Name `xml:"Response"`
+	Play    string   `xml:"Play"`
+}
+
+// handleTwiML responds with TwiML that plays the audio of the request, so
+// an IVR can point at the proxy directly. It takes the same query
+// parameters as GET /tts, the audio is 8kHz μ-law unless format is set.
+func handleTwiML(w http.ResponseWriter, r *http.Request) {
+	if len(apiKeys) > 0 && signingSecret == "" {
+		// twilio can't send the api key, the audio url has to be signed
+		writeError(w, &apiError{Code: "signing_required", Message: "/tts.twiml needs SIGNING_SECRET when API_KEYS is set"}, http.StatusNotImplemented)
+		return
+	}
+	query := withoutCallParams(r.URL.Query())
+	if err := verifySignature(query); err != nil {
+		writeError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	if query.Get("format") == "" {
+		query.Set("format", "mulaw")
+	}
+	ttsRequest := ttsRequestFromQuery(query)
+	if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// the audio url is fetched without the api key, so it's signed for the
+	// client and gets its limits, credentials and namespace
+	query.Del("signature")
+	query.Del("expires")
+	query.Del("client")
+	if name := clientName(r.Context()); name != "" && name != "signed-url" {
+		query.Set("client", name)
+	}
+	if signingSecret != "" {
+		query.Set("expires", strconv.FormatInt(time.Now().Add(twimlURLExpiry).Unix(), 10))
+		query.Set("signature", hex.EncodeToString(sign(query)))
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(twimlResponse{Play: baseURL(r) + "/tts?" + query.Encode()})
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	"golang.org/x/net/websocket"
+)
+
+// wsMessage is a message of the azure websocket synthesis protocol, text
+// messages carry the headers in front of the body and binary ones prefix
+// them with their length.
+type wsMessage struct {
+	binary  bool
+	headers map[string]string
+	body    []byte
+}
+
+var wsCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		return []byte(v.(string)), websocket.TextFrame, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		msg := v.(*wsMessage)
+		msg.binary = payloadType == websocket.BinaryFrame
+		var head []byte
+		if msg.binary {
+			if len(data) < 2 {
+				return errors.New("invalid binary message")
+			}
+			size := int(binary.BigEndian.Uint16(data))
+			if len(data) < 2+size {
+				return errors.New("invalid binary message")
+			}
+			head, msg.body = data[2:2+size], data[2+size:]
+		} else {
+			head, msg.body, _ = bytes.Cut(data, []byte("\r\n\r\n"))
+		}
+		msg.headers = map[string]string{}
+		for _, line := range strings.Split(string(head), "\r\n") {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				msg.headers[strings.ToLower(name)] = strings.TrimSpace(value)
+			}
+		}
+		return nil
+	},
+}
+
+// synthesizeWithEvents synthesizes the request over the azure websocket api,
+// which also reports when each word is spoken and the visemes.
+func synthesizeWithEvents(ctx context.Context, ttsRequest TTSRequest) (cache.Entry, error) {
+	release, err := acquireSynthesisSlot(ctx)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	defer release()
+
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "azure.websocket")
+	ctx, cancel := context.WithTimeout(ctx, azure.Client.Timeout)
+	defer cancel()
+
+	endpoint := synthesisEndpoint(ttsRequest)
+	url := fmt.Sprintf("%s/cognitiveservices/websocket/v1?X-ConnectionId=%s", strings.Replace(endpoint, "https://", "wss://", 1), newWSID())
+	if ttsRequest.DeploymentID != "" {
+		url += "&deploymentId=" + neturl.QueryEscape(ttsRequest.DeploymentID)
+	}
+	config, err := websocket.NewConfig(url, endpoint)
+	if err != nil {
+		endSpan(span, err)
+		return cache.Entry{}, err
+	}
+	if err := azure.SetAuth(ctx, config.Header, ttsRequest.AzureRegion, ttsRequest.AzureKey); err != nil {
+		endSpan(span, err)
+		return cache.Entry{}, err
+	}
+
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		endSpan(span, err)
+		return cache.Entry{}, err
+	}
+	defer ws.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		ws.SetDeadline(deadline)
+	}
+	// closing the connection unblocks the reads when the client goes away
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	entry, err := exchangeEvents(ws, ttsRequest)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	endSpan(span, err)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+
+	requestInfoFrom(ctx).AzureLatency = time.Since(start)
+	return entry, nil
+}
+
+func exchangeEvents(ws *websocket.Conn, ttsRequest TTSRequest) (cache.Entry, error) {
+	requestID := newWSID()
+	synthesisContext, _ := json.Marshal(map[string]interface{}{
+		"synthesis": map[string]interface{}{
+			"audio": map[string]interface{}{
+				"metadataOptions": map[string]interface{}{
+					"wordBoundaryEnabled":     true,
+					"sentenceBoundaryEnabled": false,
+					"visemeEnabled":           true,
+				},
+				"outputFormat": outputFormat,
+			},
+		},
+	})
+	messages := []string{
+		wsTextMessage("synthesis.context", requestID, "application/json", string(synthesisContext)),
+		wsTextMessage("ssml", requestID, "application/ssml+xml", buildSSML(ttsRequest)),
+	}
+	for _, message := range messages {
+		if err := wsCodec.Send(ws, message); err != nil {
+			return cache.Entry{}, err
+		}
+	}
+
+	audio := &bytes.Buffer{}
+	words := []cache.WordBoundary{}
+	visemes := []cache.Viseme{}
+	for {
+		var msg wsMessage
+		if err := wsCodec.Receive(ws, &msg); err != nil {
+			return cache.Entry{}, fmt.Errorf("%w: %v", errStreamInterrupted, err)
+		}
+
+		switch msg.headers["path"] {
+		case "audio":
+			audio.Write(msg.body)
+		case "audio.metadata":
+			var metadata struct {
+				Metadata []struct {
+					Type string
+					Data struct {
+						Offset   int64
+						Duration int64
+						VisemeID int `json:"VisemeId"`
+						Text     struct {
+							Text string
+						} `json:"text"`
+					}
+				}
+			}
+			if err := json.Unmarshal(msg.body, &metadata); err != nil {
+				return cache.Entry{}, err
+			}
+			// azure reports the positions in 100ns ticks
+			for _, event := range metadata.Metadata {
+				switch event.Type {
+				case "WordBoundary":
+					words = append(words, cache.WordBoundary{
+						Text:     event.Data.Text.Text,
+						Offset:   event.Data.Offset / 10000,
+						Duration: event.Data.Duration / 10000,
+					})
+				case "Viseme":
+					visemes = append(visemes, cache.Viseme{ID: event.Data.VisemeID, Offset: event.Data.Offset / 10000})
+				}
+			}
+		case "turn.end":
+			return cache.Entry{
+				Audio:     audio.Bytes(),
+				Type:      "audio/mpeg",
+				Text:      requestText(ttsRequest),
+				Voice:     ttsRequest.Name,
+				Language:  ttsRequest.Language,
+				Created:   time.Now(),
+				HasEvents: true,
+				Words:     words,
+				Visemes:   visemes,
+			}, nil
+		}
+	}
+}
+
+func wsTextMessage(path, requestID, contentType, body string) string {
+	return fmt.Sprintf("X-RequestId:%s\r\nX-Timestamp:%s\r\nContent-Type:%s\r\nPath:%s\r\n\r\n%s",
+		requestID, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), contentType, path, body)
+}
+
+func newWSID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleTimingsRequest responds with the word timings (and visemes with
+// ?include=visemes) of the audio that /tts returns for the same request.
+func handleTimingsRequest(w http.ResponseWriter, r *http.Request) {
+	includeVisemes := false
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		includeVisemes = includeVisemes || include == "visemes"
+	}
+
+	entry, key, ok := timedEntry(w, r, includeVisemes)
+	if !ok {
+		return
+	}
+
+	words := entry.Words
+	if words == nil {
+		words = []cache.WordBoundary{}
+	}
+	result := map[string]interface{}{
+		"key":   key,
+		"words": words,
+	}
+	if includeVisemes {
+		visemes := entry.Visemes
+		if visemes == nil {
+			visemes = []cache.Viseme{}
+		}
+		result["visemes"] = visemes
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// timedEntry returns the cached entry with the timings for the request,
+// synthesizing it again if the cached audio was created without them.
+// It responds with an error and returns false if that fails.
+func timedEntry(w http.ResponseWriter, r *http.Request, needVisemes bool) (cache.Entry, string, bool) {
+	var ttsRequest TTSRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		if err := verifySignature(query); err != nil {
+			writeError(w, err, http.StatusUnauthorized)
+			return cache.Entry{}, "", false
+		}
+		ttsRequest = ttsRequestFromQuery(query)
+	} else {
+		if err := readJSON(w, r, &ttsRequest); err != nil {
+			bodyError(w, err)
+			return cache.Entry{}, "", false
+		}
+	}
+
+	if err := prepareRequest(r.Context(), &ttsRequest); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return cache.Entry{}, "", false
+	}
+	if ttsRequest.Split || ttsRequest.Format != "" || ttsRequest.Provider != "azure" {
+		httpError(w, "timings are only available for azure requests without split or format", http.StatusBadRequest)
+		return cache.Entry{}, "", false
+	}
+
+	key := cacheKey(ttsRequest)
+	info := requestInfoFrom(r.Context())
+	info.Voice = ttsRequest.Name
+	info.Cache = "hit"
+
+	entry, temp, ok := cachedEntry(key)
+	ok = ok && !ttsRequest.ForceRefresh
+	// entries cached before visemes were collected don't have them
+	if ok && entry.HasEvents && (!needVisemes || entry.Visemes != nil) {
+		recordHit(key, entry, temp)
+		return entry, key, true
+	}
+
+	info.Cache = "miss"
+	chars := requestChars(ttsRequest)
+	if !checkSynthesisAllowed(w, r, ttsRequest, chars) {
+		return cache.Entry{}, "", false
+	}
+
+	entry, err := synthesizeWithEvents(r.Context(), ttsRequest)
+	if err == nil {
+		entry, err = normalizeEntry(r.Context(), entry, ttsRequest)
+	}
+	if err != nil {
+		if r.Context().Err() == nil && !synthesisBusy(w, err) && !azureFailed(w, ttsRequest, err) {
+			writeError(w, err, http.StatusInternalServerError)
+		}
+		return cache.Entry{}, "", false
+	}
+	usage.add(clientName(r.Context()), chars)
+	replaceEntry(ttsRequest, key, entry)
+	return entry, key, true
+}
+
+// replaceEntry stores the entry even if the key is already cached.
+func replaceEntry(ttsRequest TTSRequest, key string, entry cache.Entry) {
+	ttsRequest.ForceRefresh = true
+	storeEntry(ttsRequest, key, entry)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// ffmpegPath is the ffmpeg binary used to transcode the audio to other
+// formats, transcoding is disabled when it's not set
+var ffmpegPath string
+
+// audioFormat is an encoding the audio can be transcoded to
+type audioFormat struct {
+	contentType string
+	args        []string
+}
+
+// audioFormats are the values of the format of a request
+var audioFormats = map[string]audioFormat{
+	"mp3":   {"audio/mpeg", []string{"-c:a", "libmp3lame", "-b:a", "64k", "-f", "mp3"}},
+	"opus":  {"audio/ogg; codecs=opus", []string{"-c:a", "libopus", "-b:a", "32k", "-f", "ogg"}},
+	"webm":  {"audio/webm; codecs=opus", []string{"-c:a", "libopus", "-b:a", "32k", "-f", "webm"}},
+	"wav":   {"audio/wav", []string{"-c:a", "pcm_s16le", "-f", "wav"}},
+	"mulaw": {"audio/wav; codecs=mulaw", []string{"-ar", "8000", "-ac", "1", "-c:a", "pcm_mulaw", "-f", "wav"}},
+	"alaw":  {"audio/wav; codecs=alaw", []string{"-ar", "8000", "-ac", "1", "-c:a", "pcm_alaw", "-f", "wav"}},
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(audioFormats))
+	for name := range audioFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validateFormat checks the format of a request, mp3 is what azure returns
+// so it doesn't have to be transcoded.
+func validateFormat(ttsRequest *TTSRequest) error {
+	if ttsRequest.Format == "mp3" && ttsRequest.Provider == "azure" {
+		ttsRequest.Format = ""
+	}
+	if ttsRequest.Format == "" {
+		return nil
+	}
+	if _, ok := audioFormats[ttsRequest.Format]; !ok {
+		return fieldError("invalid_format", "format", "format must be one of %s", formatNames())
+	}
+	if ffmpegPath == "" && !nativeFormat(*ttsRequest) {
+		return fieldError("transcoding_disabled", "format", "format is not enabled on this server, set FFMPEG_PATH to enable it")
+	}
+	if ttsRequest.Split {
+		return fieldError("conflicting_fields", "format", "format can't be used with split")
+	}
+	return nil
+}
+
+// transcoded reports whether the audio of the request is transcoded from
+// the audio of the provider.
+func transcoded(ttsRequest TTSRequest) bool {
+	return ttsRequest.Format != "" && !nativeFormat(ttsRequest)
+}
+
+// sourceRequest is the request of the audio a transcoded request is
+// transcoded from.
+func sourceRequest(ttsRequest TTSRequest) TTSRequest {
+	ttsRequest.Format = ""
+	return ttsRequest
+}
+
+// sourceAudio returns the audio the request is transcoded from, from the
+// cache or synthesized and cached like a request without format. The
+// characters are counted for the transcoded request.
+func sourceAudio(ctx context.Context, ttsRequest TTSRequest) (cache.Entry, error) {
+	source := sourceRequest(ttsRequest)
+	key := cacheKey(source)
+	if entry, ok := lookupEntry(key); ok && !source.ForceRefresh {
+		return entry, nil
+	}
+
+	leader := false
+	val, err, _ := synthesisGroup.Do(key, func() (interface{}, error) {
+		leader = true
+		return synthesize(ctx, discardResponseWriter{}, source, key)
+	})
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	entry := val.(cache.Entry)
+	if !leader && source.ShouldCache {
+		storeEntry(source, key, entry)
+	}
+	return entry, nil
+}
+
+// transcode converts the audio to the format with ffmpeg.
+func transcode(ctx context.Context, entry cache.Entry, format string) (io.ReadCloser, string, error) {
+	target, ok := audioFormats[format]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown format %q", format)
+	}
+	source, err := openEntry(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	defer source.Close()
+
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0"}, target.args...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, append(args, "pipe:1")...)
+	cmd.Stdin = source
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	audio, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
+		}
+		slog.Error("Transcoding failed", "format", format, "error", err, "stderr", stderr.String())
+		return nil, "", errors.New("transcoding the audio failed")
+	}
+	if len(audio) == 0 {
+		return nil, "", errors.New("transcoding the audio returned no audio")
+	}
+	return io.NopCloser(bytes.NewReader(audio)), target.contentType, nil
+}
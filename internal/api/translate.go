@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+var translatorKey string
+var translatorRegion string
+
+// translations are cached apart from the audio, by the key of translationCacheKey
+var translationsC = gocache.New(24*time.Hour, time.Hour)
+
+type translateRequest struct {
+	TTSRequest
+	// To is the language to translate to, default is the language of the voice
+	To   string `json:"to"`
+	From string `json:"from"`
+}
+
+func translationCacheKey(text, from, to string) string {
+	h := sha256.New()
+	for _, part := range []string{"translate", text, from, to} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// handleTranslateTTSRequest translates the text with azure translator and
+// responds with the audio of the translation, both are cached.
+func handleTranslateTTSRequest(w http.ResponseWriter, r *http.Request) {
+	if translatorKey == "" {
+		httpError(w, "translation is not enabled on this server", http.StatusNotFound)
+		return
+	}
+
+	var req translateRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		if err := verifySignature(query); err != nil {
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+		req = translateRequest{TTSRequest: ttsRequestFromQuery(query), To: query.Get("to"), From: query.Get("from")}
+	} else {
+		if err := readJSON(w, r, &req); err != nil {
+			bodyError(w, err)
+			return
+		}
+	}
+
+	if req.Text == "" || req.SSML != "" || len(req.Segments) > 0 || req.AllowMarkup {
+		httpError(w, "text is required and only plain text can be translated", http.StatusBadRequest)
+		return
+	}
+	if req.To == "" {
+		req.To, _, _ = strings.Cut(req.Language, "-")
+	}
+	if req.To == "" {
+		httpError(w, "to or language is required", http.StatusBadRequest)
+		return
+	}
+	// invalid requests don't use translator quota, serveTTS prepares the
+	// request with the translation again
+	check := req.TTSRequest
+	if err := prepareRequest(r.Context(), &check); err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	translation, err := translate(r.Context(), req.Text, req.From, req.To)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		writeError(w, err, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("X-Translation", url.QueryEscape(translation))
+	ttsRequest := req.TTSRequest
+	ttsRequest.Text = translation
+	serveTTS(w, r, ttsRequest)
+}
+
+// translate returns the cached translation or requests it from azure translator.
+func translate(ctx context.Context, text, from, to string) (string, error) {
+	key := translationCacheKey(text, from, to)
+	if translation, ok := translationsC.Get(key); ok {
+		return translation.(string), nil
+	}
+
+	query := url.Values{"api-version": {"3.0"}, "to": {to}}
+	if from != "" {
+		query.Set("from", from)
+	}
+	body, _ := json.Marshal([]map[string]string{{"Text": text}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, azure.Cloud.Translator+"/translate?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ocp-Apim-Subscription-Key", translatorKey)
+	region := translatorRegion
+	if region == "" {
+		region = azureRegion
+	}
+	if region != "" {
+		req.Header.Set("Ocp-Apim-Subscription-Region", region)
+	}
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("translator returned %d", resp.StatusCode)
+	}
+
+	var result []struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result) == 0 || len(result[0].Translations) == 0 {
+		return "", errors.New("translator returned no translation")
+	}
+
+	translation := result[0].Translations[0].Text
+	translationsC.Set(key, translation, gocache.DefaultExpiration)
+	return translation, nil
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// cacheTTL is how long cached audio is kept when the request doesn't
+// set ttlSeconds, 0 means forever
+var cacheTTL time.Duration
+
+// maxCacheTTL caps the ttl of the requests, 0 means no limit
+var maxCacheTTL time.Duration
+
+// tempCacheTTL is how long audio of requests without shouldCache is kept
+var tempCacheTTL = 5 * time.Minute
+
+// slidingExpiration extends the ttl of entries when they're served, so
+// phrases that are used stay cached and unused ones expire
+var slidingExpiration bool
+
+// entryTTL returns how long the audio of the request is cached, 0 if it
+// doesn't expire.
+func entryTTL(ttsRequest TTSRequest) time.Duration {
+	ttl := cacheTTL
+	if ttsRequest.TTLSeconds != nil {
+		ttl = time.Duration(*ttsRequest.TTLSeconds) * time.Second
+	}
+	if maxCacheTTL > 0 && (ttl == 0 || ttl > maxCacheTTL) {
+		ttl = maxCacheTTL
+	}
+	return ttl
+}
+
+// remainingTTL returns the ttl to store a saved entry with again, false
+// if it has expired in the meantime.
+func remainingTTL(entry cache.Entry) (time.Duration, bool) {
+	if entry.Expires.IsZero() {
+		return 0, true
+	}
+	ttl := time.Until(entry.Expires)
+	return ttl, ttl > 0
+}
+
+// touchEntry extends the ttl of a served entry with sliding expiration.
+func touchEntry(key string, entry cache.Entry) {
+	if !slidingExpiration || entry.TTL <= 0 {
+		return
+	}
+	// the whole entry is stored again, so it's only extended once a tenth
+	// of the ttl has passed
+	if entry.TTL-time.Until(entry.Expires) < entry.TTL/10 {
+		return
+	}
+	entry.Expires = time.Now().Add(entry.TTL)
+	c.Set(key, entry, entry.TTL)
+	cacheSet(key, entry)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+)
+
+// usageTracker counts characters sent to Azure per client and month.
+type usageTracker struct {
+	mu     sync.Mutex
+	months map[string]map[string]int64
+	dirty  atomic.Bool
+}
+
+var usage = &usageTracker{months: make(map[string]map[string]int64)}
+
+// monthlyCharQuota limits characters per client and month, 0 means no limit
+var monthlyCharQuota int64
+
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+func usageClient(name string) string {
+	if name == "" {
+		return "anonymous"
+	}
+	return name
+}
+
+func (u *usageTracker) add(client string, chars int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	month := usageMonth(time.Now())
+	if u.months[month] == nil {
+		u.months[month] = make(map[string]int64)
+	}
+	u.months[month][usageClient(client)] += chars
+	u.dirty.Store(true)
+}
+
+func (u *usageTracker) get(month, client string) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.months[month][usageClient(client)]
+}
+
+func (u *usageTracker) month(month string) map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	result := make(map[string]int64, len(u.months[month]))
+	for client, chars := range u.months[month] {
+		result[client] = chars
+	}
+	return result
+}
+
+// quotaExceeded reports whether the client can't send chars more characters this month.
+func (u *usageTracker) quotaExceeded(client string, chars int64) bool {
+	if monthlyCharQuota <= 0 {
+		return false
+	}
+	return u.get(usageMonth(time.Now()), client)+chars > monthlyCharQuota
+}
+
+func usageFile() string {
+	return filepath.Join(cacheDir, "usage.json")
+}
+
+func (u *usageTracker) load() {
+	data, err := os.ReadFile(usageFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Failed to read usage file", "error", err)
+		}
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := json.Unmarshal(data, &u.months); err != nil {
+		slog.Error("Failed to decode usage file", "error", err)
+		u.months = make(map[string]map[string]int64)
+	}
+}
+
+func (u *usageTracker) save() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.dirty.Store(false)
+
+	if err := cache.WriteFileAtomic(usageFile(), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(u.months)
+	}); err != nil {
+		slog.Error("Failed to save usage", "error", err)
+		u.dirty.Store(true)
+	}
+}
+
+// untilNextMonth is how long until the monthly quota resets.
+func untilNextMonth() time.Duration {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+func handleUsageRequest(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = usageMonth(time.Now())
+	}
+
+	clients := usage.month(month)
+	// clients only see their own usage when api keys are used
+	if len(apiKeys) > 0 {
+		client := usageClient(clientName(r.Context()))
+		clients = map[string]int64{client: clients[client]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"month":   month,
+		"quota":   monthlyCharQuota,
+		"clients": clients,
+	})
+}
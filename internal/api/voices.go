@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+type Voice struct {
+	Name                string   `json:"Name"`
+	DisplayName         string   `json:"DisplayName"`
+	LocalName           string   `json:"LocalName"`
+	ShortName           string   `json:"ShortName"`
+	Gender              string   `json:"Gender"`
+	Locale              string   `json:"Locale"`
+	LocaleName          string   `json:"LocaleName"`
+	StyleList           []string `json:"StyleList,omitempty"`
+	RolePlayList        []string `json:"RolePlayList,omitempty"`
+	SecondaryLocaleList []string `json:"SecondaryLocaleList,omitempty"`
+	SampleRateHertz     string   `json:"SampleRateHertz"`
+	VoiceType           string   `json:"VoiceType"`
+	Status              string   `json:"Status"`
+}
+
+type voicesEntry struct {
+	Raw    []byte
+	Voices []Voice
+}
+
+var validateVoices bool
+
+// voices lists are cached per region
+var voicesC = gocache.New(24*time.Hour, time.Hour)
+
+func handleVoicesRequest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, region, err := resolveCredentials(r.Context(), query.Get("azureKey"), query.Get("azureRegion"))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	entry, err := getVoices(key, region)
+	if err != nil {
+		writeError(w, err, http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(entry.Raw)
+}
+
+func getVoices(key, region string) (voicesEntry, error) {
+	if val, ok := voicesC.Get(region); ok {
+		return val.(voicesEntry), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, azure.RegionEndpoint(azure.Cloud.TTS, region)+"/cognitiveservices/voices/list", nil)
+	if err != nil {
+		return voicesEntry{}, err
+	}
+	if err := azure.SetAuth(context.Background(), req.Header, region, key); err != nil {
+		return voicesEntry{}, err
+	}
+
+	resp, err := azure.Client.Do(req)
+	if err != nil {
+		return voicesEntry{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return voicesEntry{}, fmt.Errorf("Azure returned %d", resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return voicesEntry{}, err
+	}
+
+	entry := voicesEntry{Raw: raw}
+	if err := json.Unmarshal(raw, &entry.Voices); err != nil {
+		return voicesEntry{}, err
+	}
+
+	voicesC.Set(region, entry, gocache.DefaultExpiration)
+	return entry, nil
+}
+
+// validateVoice checks the voice name, language, style and role against the voices list.
+// If the list can't be fetched the request is let through.
+func validateVoice(r TTSRequest) error {
+	for _, segment := range r.Segments {
+		if err := validateVoice(segmentRequest(r, segment)); err != nil {
+			return err
+		}
+	}
+
+	// custom voices aren't in the voices list
+	if !validateVoices || r.Name == "" || r.SSML != "" || r.DeploymentID != "" || r.Provider != "azure" {
+		return nil
+	}
+
+	entry, err := getVoices(r.AzureKey, r.AzureRegion)
+	if err != nil {
+		slog.Warn("Failed to get voices list, skipping validation", "error", err)
+		return nil
+	}
+
+	var voice *Voice
+	for i := range entry.Voices {
+		if entry.Voices[i].ShortName == r.Name || entry.Voices[i].Name == r.Name {
+			voice = &entry.Voices[i]
+			break
+		}
+	}
+	if voice == nil {
+		return fieldError("invalid_voice", "name", "unknown voice %q, see /voices for available voices", r.Name)
+	}
+
+	if r.Language != "" && !strings.EqualFold(r.Language, voice.Locale) && !slices.ContainsFunc(voice.SecondaryLocaleList, func(l string) bool {
+		return strings.EqualFold(l, r.Language)
+	}) {
+		return fieldError("unsupported_language", "language", "voice %s doesn't support language %q, use %s", voice.ShortName, r.Language, voice.Locale)
+	}
+
+	if r.Style != "" && !slices.Contains(voice.StyleList, r.Style) {
+		if len(voice.StyleList) == 0 {
+			return fieldError("unsupported_style", "style", "voice %s doesn't support styles", voice.ShortName)
+		}
+		return fieldError("unsupported_style", "style", "voice %s doesn't support style %q, available styles: %s", voice.ShortName, r.Style, strings.Join(voice.StyleList, ", "))
+	}
+
+	if r.Role != "" && !slices.Contains(voice.RolePlayList, r.Role) {
+		if len(voice.RolePlayList) == 0 {
+			return fieldError("unsupported_role", "role", "voice %s doesn't support roles", voice.ShortName)
+		}
+		return fieldError("unsupported_role", "role", "voice %s doesn't support role %q, available roles: %s", voice.ShortName, r.Role, strings.Join(voice.RolePlayList, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// walEnabled appends every change of the permanent cache to a log next to
+// the cache file as it happens, the whole cache is only saved every
+// snapshotInterval and the log is emptied then
+var walEnabled bool
+var snapshotInterval time.Duration
+
+// lastSnapshot is when the cache file was last written, guarded by saveMu
+var lastSnapshot time.Time
+
+var walMu sync.Mutex
+var walWriter *os.File
+
+// walRecord is a change of the permanent cache, Entry is nil for deletes.
+type walRecord struct {
+	Key   string
+	Entry *cache.Entry
+}
+
+func walFile() string {
+	return cacheFile + ".wal"
+}
+
+// walSnapshotFile has the changes of the log while a snapshot is written,
+// it's removed once the snapshot is saved.
+func walSnapshotFile() string {
+	return cacheFile + ".wal.old"
+}
+
+// openWAL opens the log for appending, it's done after the cache is loaded
+// so the replayed changes aren't appended again.
+func openWAL() error {
+	if !persist || !walEnabled {
+		return nil
+	}
+	walMu.Lock()
+	defer walMu.Unlock()
+	file, err := os.OpenFile(walFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	walWriter = file
+	return nil
+}
+
+func closeWAL() {
+	walMu.Lock()
+	defer walMu.Unlock()
+	if walWriter != nil {
+		walWriter.Close()
+		walWriter = nil
+	}
+}
+
+// cacheSet records that the entry was added to the permanent cache.
+func cacheSet(key string, entry cache.Entry) {
+	if !persist {
+		return
+	}
+	dirty.Store(true)
+	if backend == "disk" && entry.Audio != nil {
+		// the audio is already in the blob directory
+		entry.Blob, entry.Size, entry.Audio = cache.Hash(entry), int64(len(entry.Audio)), nil
+	}
+	appendWAL(walRecord{Key: key, Entry: &entry})
+}
+
+// cacheDeleted records that the entry was removed from the permanent cache.
+func cacheDeleted(key string) {
+	if !persist {
+		return
+	}
+	dirty.Store(true)
+	appendWAL(walRecord{Key: key})
+}
+
+// appendWAL writes the record in a frame with its length and checksum, so
+// a record cut off by a crash or damaged on disk can be told apart from the
+// intact ones.
+func appendWAL(record walRecord) {
+	walMu.Lock()
+	defer walMu.Unlock()
+	if walWriter == nil {
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(record); err != nil {
+		slog.Error("Failed to encode cache log record", "error", err)
+		return
+	}
+	var frame bytes.Buffer
+	writeFrame(&frame, cache.Seal(buffer.Bytes()))
+	if _, err := walWriter.Write(frame.Bytes()); err != nil {
+		slog.Error("Failed to append to cache log", "error", err)
+		return
+	}
+	// the change is only safe from a crash of the machine once it's on disk
+	if err := walWriter.Sync(); err != nil {
+		slog.Error("Failed to sync cache log", "error", err)
+	}
+}
+
+// replayWAL applies the changes logged since the last snapshot to items.
+func replayWAL(items map[string]gocache.Item) int {
+	replayed := 0
+	for _, path := range []string{walSnapshotFile(), walFile()} {
+		replayed += replayWALFile(path, items)
+	}
+	return replayed
+}
+
+func replayWALFile(path string, items map[string]gocache.Item) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Failed to read cache log", "error", err)
+		}
+		return 0
+	}
+
+	replayed, corrupted, offset := 0, 0, 0
+	for offset < len(data) {
+		record, size, err := decodeWALRecord(data[offset:])
+		if err != nil {
+			// the length can be damaged too, so it continues at the next
+			// intact record instead of skipping size bytes
+			next := nextWALRecord(data, offset+1)
+			if size == 0 && next == len(data) {
+				// the rest was cut off by a crash, it's removed so new
+				// records aren't appended after it
+				slog.Warn("Cache log is truncated, dropping the rest of it", "file", path, "offset", offset, "error", err)
+				if err := os.Truncate(path, int64(offset)); err != nil {
+					slog.Error("Failed to truncate cache log", "error", err)
+				}
+				break
+			}
+			corrupted++
+			offset = next
+			continue
+		}
+		offset += size
+		replayed++
+
+		if record.Entry == nil {
+			delete(items, record.Key)
+		} else {
+			items[record.Key] = gocache.Item{Object: *record.Entry}
+		}
+	}
+	if corrupted > 0 {
+		slog.Warn("Skipped corrupted cache log records", "file", path, "count", corrupted)
+	}
+	return replayed
+}
+
+// decodeWALRecord returns the record at the start of data and its size. The
+// size is also returned when the record is corrupted, but not when it's cut off.
+func decodeWALRecord(data []byte) (walRecord, int, error) {
+	var record walRecord
+	sealed, size, err := readFrame(data)
+	if err != nil {
+		return record, size, err
+	}
+	payload, err := cache.Unseal(sealed)
+	if err != nil {
+		return record, size, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+		return record, size, err
+	}
+	if record.Key == "" {
+		return record, size, errors.New("record has no key")
+	}
+	return record, size, nil
+}
+
+// nextWALRecord returns the offset of the next intact record from offset
+// on, or the length of data when there's none.
+func nextWALRecord(data []byte, offset int) int {
+	for ; offset < len(data); offset++ {
+		if _, _, err := decodeWALRecord(data[offset:]); err == nil {
+			return offset
+		}
+	}
+	return len(data)
+}
+
+// rotateWAL moves the logged changes aside before a snapshot is taken, the
+// changes made while it's written go to a new log.
+func rotateWAL() {
+	walMu.Lock()
+	defer walMu.Unlock()
+	if walWriter == nil {
+		return
+	}
+	walWriter.Close()
+	walWriter = nil
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if _, err := os.Stat(walSnapshotFile()); err == nil {
+		// the previous snapshot failed, its changes are kept too
+		if err := appendFile(walSnapshotFile(), walFile()); err != nil {
+			slog.Error("Failed to rotate cache log", "error", err)
+		} else {
+			flags |= os.O_TRUNC
+		}
+	} else if err := os.Rename(walFile(), walSnapshotFile()); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to rotate cache log", "error", err)
+	}
+
+	file, err := os.OpenFile(walFile(), flags, 0o644)
+	if err != nil {
+		slog.Error("Failed to open cache log", "error", err)
+		return
+	}
+	walWriter = file
+}
+
+func appendFile(dst, src string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	file, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// snapshotDue reports whether the cache file should be written, with the
+// log it's only rewritten every snapshotInterval.
+func snapshotDue() bool {
+	if !walEnabled {
+		return true
+	}
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	return time.Since(lastSnapshot) >= snapshotInterval
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/cache"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// withWAL logs the changes of the cache to a log in a temporary directory.
+func withWAL(t *testing.T) {
+	t.Helper()
+	oldFile, oldPersist, oldEnabled := cacheFile, persist, walEnabled
+	cacheFile, persist, walEnabled = filepath.Join(t.TempDir(), "cache-data.bin"), true, true
+	t.Cleanup(func() {
+		closeWAL()
+		cacheFile, persist, walEnabled = oldFile, oldPersist, oldEnabled
+	})
+	if err := openWAL(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestWAL logs the adding of a, b and c and the deletion of b, and
+// returns the offsets of the records in the log.
+func writeTestWAL(t *testing.T) []int64 {
+	t.Helper()
+	var offsets []int64
+	log := func(change func()) {
+		info, err := os.Stat(walFile())
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, info.Size())
+		change()
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		log(func() { cacheSet(key, cache.Entry{Audio: []byte("audio " + key), Text: key}) })
+	}
+	log(func() { cacheDeleted("b") })
+	closeWAL()
+	return offsets
+}
+
+func TestWALRoundTrip(t *testing.T) {
+	withWAL(t)
+	writeTestWAL(t)
+
+	items := map[string]gocache.Item{}
+	if replayed := replayWAL(items); replayed != 4 {
+		t.Errorf("replayed %d records, want 4", replayed)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d entries, want a and c", len(items))
+	}
+	for _, key := range []string{"a", "c"} {
+		entry, ok := items[key].Object.(cache.Entry)
+		if !ok || entry.Text != key || string(entry.Audio) != "audio "+key {
+			t.Errorf("entry %s = %+v", key, items[key].Object)
+		}
+	}
+}
+
+func TestWALDropsTruncatedRecord(t *testing.T) {
+	withWAL(t)
+	offsets := writeTestWAL(t)
+
+	// a crash while the delete of b was written
+	cut := offsets[3] + 5
+	if err := os.Truncate(walFile(), cut); err != nil {
+		t.Fatal(err)
+	}
+
+	items := map[string]gocache.Item{}
+	if replayed := replayWAL(items); replayed != 3 {
+		t.Errorf("replayed %d records, want 3", replayed)
+	}
+	if _, ok := items["b"]; !ok {
+		t.Error("the change before the cut off record is missing")
+	}
+	info, err := os.Stat(walFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != offsets[3] {
+		t.Errorf("log is %d bytes, want it truncated to the intact records (%d bytes)", info.Size(), offsets[3])
+	}
+}
+
+func TestWALSkipsCorruptedRecord(t *testing.T) {
+	for name, offset := range map[string]func(offsets []int64) int64{
+		// a flipped bit in the audio of b
+		"payload": func(offsets []int64) int64 { return offsets[2] - 1 },
+		// a length that points past the end of the log
+		"length": func(offsets []int64) int64 { return offsets[1] },
+	} {
+		t.Run(name, func(t *testing.T) {
+			withWAL(t)
+			offsets := writeTestWAL(t)
+
+			data, err := os.ReadFile(walFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[offset(offsets)] ^= 0xff
+			if err := os.WriteFile(walFile(), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			items := map[string]gocache.Item{}
+			if replayed := replayWAL(items); replayed != 3 {
+				t.Errorf("replayed %d records, want 3", replayed)
+			}
+			for _, key := range []string{"a", "c"} {
+				if _, ok := items[key]; !ok {
+					t.Errorf("entry %s next to the corrupted record is missing", key)
+				}
+			}
+			info, err := os.Stat(walFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(data)) {
+				t.Error("the intact records after the corrupted one were truncated")
+			}
+		})
+	}
+}
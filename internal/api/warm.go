@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var warmConcurrency = 4
+
+const maxWarmErrors = 100
+
+type warmJob struct {
+	mu       sync.Mutex
+	ID       string
+	Status   string
+	Total    int
+	Done     int
+	Skipped  int
+	Failed   int
+	Errors   []string
+	Created  time.Time
+	Finished time.Time
+}
+
+var warmJobsMu sync.Mutex
+var warmJobs = map[string]*warmJob{}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// handleWarmCache starts synthesizing the requests in the background
+// and responds with the id of the job.
+func handleWarmCache(w http.ResponseWriter, r *http.Request) {
+	var requests []TTSRequest
+	if err := readJSON(w, r, &requests); err != nil {
+		bodyError(w, err)
+		return
+	}
+	if len(requests) == 0 {
+		httpError(w, "no requests to warm", http.StatusBadRequest)
+		return
+	}
+
+	job := &warmJob{ID: newJobID(), Status: "running", Total: len(requests), Created: time.Now()}
+	warmJobsMu.Lock()
+	for id, old := range warmJobs {
+		if old.finishedBefore(time.Now().Add(-24 * time.Hour)) {
+			delete(warmJobs, id)
+		}
+	}
+	warmJobs[job.ID] = job
+	warmJobsMu.Unlock()
+
+	go job.run(context.WithoutCancel(r.Context()), requests)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.snapshot())
+}
+
+func handleWarmStatus(w http.ResponseWriter, r *http.Request) {
+	warmJobsMu.Lock()
+	job, ok := warmJobs[r.PathValue("id")]
+	warmJobsMu.Unlock()
+	if !ok {
+		httpError(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.snapshot())
+}
+
+func (j *warmJob) run(ctx context.Context, requests []TTSRequest) {
+	queue := make(chan TTSRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < max(warmConcurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ttsRequest := range queue {
+				// every item gets its own request info, they run concurrently
+				itemCtx := context.WithValue(ctx, requestInfoKey{}, &requestInfo{})
+				skipped, err := warm(itemCtx, ttsRequest)
+				j.record(skipped, err)
+			}
+		}()
+	}
+
+	for _, ttsRequest := range requests {
+		queue <- ttsRequest
+	}
+	close(queue)
+	wg.Wait()
+
+	j.mu.Lock()
+	j.Status = "done"
+	j.Finished = time.Now()
+	j.mu.Unlock()
+}
+
+// warm synthesizes a single request into the permanent cache,
+// skipping it if it is already cached.
+func warm(ctx context.Context, ttsRequest TTSRequest) (bool, error) {
+	ttsRequest.ShouldCache = true
+	if err := prepareRequest(ctx, &ttsRequest); err != nil {
+		return false, err
+	}
+
+	key := cacheKey(ttsRequest)
+	if _, ok := c.Get(key); ok && !ttsRequest.ForceRefresh {
+		return true, nil
+	}
+	if err := validateVoice(ttsRequest); err != nil {
+		return false, err
+	}
+
+	_, err := synthesizeInBackground(ctx, ttsRequest, key)
+	return false, err
+}
+
+func (j *warmJob) record(skipped bool, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Done++
+	switch {
+	case err != nil:
+		j.Failed++
+		if len(j.Errors) < maxWarmErrors {
+			j.Errors = append(j.Errors, err.Error())
+		}
+	case skipped:
+		j.Skipped++
+	}
+}
+
+func (j *warmJob) finishedBefore(t time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.Finished.IsZero() && j.Finished.Before(t)
+}
+
+func (j *warmJob) snapshot() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	result := map[string]interface{}{
+		"id":      j.ID,
+		"status":  j.Status,
+		"total":   j.Total,
+		"done":    j.Done,
+		"skipped": j.Skipped,
+		"failed":  j.Failed,
+		"created": j.Created,
+	}
+	if len(j.Errors) > 0 {
+		result["errors"] = j.Errors
+	}
+	if !j.Finished.IsZero() {
+		result["finished"] = j.Finished
+	}
+	return result
+}
+
+// discardResponseWriter is used to synthesize audio without a client
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return http.Header{} }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var webhookSecret string
+var publicURL string
+
+// webhookClient only connects to public addresses, the check is done when
+// connecting so a host that resolves differently later is caught too
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublic}).DialContext,
+	},
+}
+
+const webhookAttempts = 3
+
+var errPrivateAddress = errors.New("callbacks to private addresses are not allowed")
+
+// publicAddress reports whether the ip can be reached from the internet, so
+// callbacks can't reach the services next to the proxy.
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+func validateCallbackURL(ctx context.Context, callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callbackUrl %q", callbackURL)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("invalid callbackUrl %q: %w", callbackURL, err)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr.IP) {
+			return fmt.Errorf("invalid callbackUrl %q: %w", callbackURL, errPrivateAddress)
+		}
+	}
+	return nil
+}
+
+// baseURL is the url clients reach the proxy at, PUBLIC_URL takes precedence
+// over the host of the request.
+func baseURL(r *http.Request) string {
+	if publicURL != "" {
+		return publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// sendWebhook posts the payload to the callback url, signed with an
+// HMAC-SHA256 of the body in the X-Signature header when WEBHOOK_SECRET is set.
+func sendWebhook(ctx context.Context, callbackURL string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Failed to encode webhook payload", "error", err)
+		return
+	}
+
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err = postWebhook(ctx, callbackURL, body)
+		if err == nil {
+			return
+		}
+		slog.Warn("Failed to deliver webhook", "url", callbackURL, "attempt", attempt, "error", err)
+		if attempt == webhookAttempts {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		}
+	}
+}
+
+func postWebhook(ctx context.Context, callbackURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(webhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("callback returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+const wsFrameSize = 32 * 1024
+
+// wsResult is the text frame sent after the audio of a request, or instead
+// of it if the request failed.
+type wsResult struct {
+	Done        bool   `json:"done"`
+	ContentType string `json:"contentType,omitempty"`
+	Cache       string `json:"cache,omitempty"`
+	Size        int64  `json:"size"`
+	Fallback    bool   `json:"fallback,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Code        string `json:"code,omitempty"`
+	Field       string `json:"field,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	RetryAfter  int    `json:"retryAfter,omitempty"`
+}
+
+// handleTTSWebSocket serves /tts over a websocket. The client sends /tts
+// request bodies as text frames and gets the audio back in binary frames as
+// it arrives from azure, followed by a wsResult frame. The connection can be
+// reused for more requests.
+func handleTTSWebSocket(w http.ResponseWriter, r *http.Request) {
+	// browsers can't set headers on websockets, so they can use a signed url instead
+	if clientName(r.Context()) == "signed-url" {
+		if err := verifySignature(r.URL.Query()); err != nil {
+			writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// non-browser clients don't send an origin, so the default handshake isn't used
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = 1 << 20
+		for {
+			var ttsRequest TTSRequest
+			if err := websocket.JSON.Receive(ws, &ttsRequest); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					websocket.JSON.Send(ws, wsResult{Error: err.Error(), Status: http.StatusBadRequest})
+					continue
+				}
+				return
+			}
+			if !serveWebSocketRequest(ws, r, ttsRequest) {
+				return
+			}
+		}
+	}}
+	server.ServeHTTP(w, r)
+}
+
+// serveWebSocketRequest streams the audio of a single request, it returns
+// false if the connection can't be used anymore.
+func serveWebSocketRequest(ws *websocket.Conn, r *http.Request, ttsRequest TTSRequest) (ok bool) {
+	if allowed, retryAfter := requestLimiter.take(rateLimitID(r), 1); !allowed {
+		err := websocket.JSON.Send(ws, wsResult{
+			Error:      "rate limit exceeded",
+			Status:     http.StatusTooManyRequests,
+			RetryAfter: int(math.Ceil(retryAfter.Seconds())),
+		})
+		return err == nil
+	}
+
+	// every request on the connection gets its own cache info
+	info := &requestInfo{}
+	ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+	w := &wsAudioWriter{ws: ws, header: http.Header{}}
+
+	defer func() {
+		// split requests abort once the audio is partially written
+		if recovered := recover(); recovered != nil {
+			if recovered != http.ErrAbortHandler {
+				panic(recovered)
+			}
+			websocket.JSON.Send(ws, wsResult{Error: "synthesis interrupted", Status: http.StatusInternalServerError})
+			ok = false
+		}
+	}()
+	serveTTS(w, r.WithContext(ctx), ttsRequest)
+
+	if w.err != nil {
+		return false
+	}
+	result := wsResult{
+		Done:        w.status < 400,
+		ContentType: w.header.Get("Content-Type"),
+		Cache:       info.Cache,
+		Size:        w.size,
+		Fallback:    w.header.Get("X-TTS-Fallback") != "",
+	}
+	if !result.Done {
+		result = wsResult{Error: strings.TrimSpace(w.body.String()), Status: w.status}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		if json.Unmarshal([]byte(w.body.String()), &body) == nil && body.Error.Code != "" {
+			result.Error, result.Code, result.Field = body.Error.Message, body.Error.Code, body.Error.Field
+		}
+		if retryAfter := w.header.Get("Retry-After"); retryAfter != "" {
+			json.Unmarshal([]byte(retryAfter), &result.RetryAfter)
+		}
+	}
+	return websocket.JSON.Send(ws, result) == nil
+}
+
+// wsAudioWriter sends the audio written by serveTTS as binary frames and
+// keeps error responses for the result frame.
+type wsAudioWriter struct {
+	ws     *websocket.Conn
+	header http.Header
+	status int
+	size   int64
+	body   strings.Builder
+	err    error
+}
+
+func (w *wsAudioWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *wsAudioWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *wsAudioWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 {
+		return w.body.Write(b)
+	}
+
+	n := len(b)
+	// keep going when the client goes away so the audio still ends up in the cache
+	for len(b) > 0 && w.err == nil {
+		frame := b[:min(len(b), wsFrameSize)]
+		w.err = websocket.Message.Send(w.ws, frame)
+		w.size += int64(len(frame))
+		b = b[len(frame):]
+	}
+	return n, nil
+}
@@ -0,0 +1,130 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// speechResourceID is the resource id of the speech resource, azure ad
+// tokens have to be sent together with it
+var speechResourceID string
+
+type aadCachedToken struct {
+	value   string
+	expires time.Time
+}
+
+var aadMu sync.Mutex
+var aadTokens = map[string]aadCachedToken{}
+
+// aadAuthorization returns the authorization header value for azure ad auth.
+func aadAuthorization(ctx context.Context) (string, error) {
+	token, err := aadToken(ctx, Cloud.Speech)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer aad#" + speechResourceID + "#" + token, nil
+}
+
+// aadToken returns a cached azure ad token for the resource, requesting
+// a new one 5 minutes before it expires.
+func aadToken(ctx context.Context, resource string) (string, error) {
+	aadMu.Lock()
+	defer aadMu.Unlock()
+	if cached, ok := aadTokens[resource]; ok && time.Until(cached.expires) > 5*time.Minute {
+		return cached.value, nil
+	}
+
+	var req *http.Request
+	var err error
+	if os.Getenv("AZURE_CLIENT_SECRET") != "" {
+		req, err = clientSecretTokenRequest(ctx, resource)
+	} else {
+		req, err = managedIdentityTokenRequest(ctx, resource)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("azure ad returned %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string          `json:"access_token"`
+		ExpiresIn   json.RawMessage `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("azure ad returned no token")
+	}
+	// managed identity endpoints return expires_in as a string
+	expiresIn, err := strconv.Atoi(strings.Trim(string(body.ExpiresIn), `"`))
+	if err != nil {
+		expiresIn = 3600
+	}
+
+	aadTokens[resource] = aadCachedToken{value: body.AccessToken, expires: time.Now().Add(time.Duration(expiresIn) * time.Second)}
+	return body.AccessToken, nil
+}
+
+// clientSecretTokenRequest uses the service principal from AZURE_TENANT_ID,
+// AZURE_CLIENT_ID and AZURE_CLIENT_SECRET.
+func clientSecretTokenRequest(ctx context.Context, resource string) (*http.Request, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {os.Getenv("AZURE_CLIENT_ID")},
+		"client_secret": {os.Getenv("AZURE_CLIENT_SECRET")},
+		"scope":         {resource + "/.default"},
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", Cloud.Authority, url.PathEscape(os.Getenv("AZURE_TENANT_ID")))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// managedIdentityTokenRequest uses the app service identity endpoint when
+// available and the instance metadata service otherwise.
+func managedIdentityTokenRequest(ctx context.Context, resource string) (*http.Request, error) {
+	query := url.Values{"resource": {resource}}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		query.Set("api-version", "2019-08-01")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+		return req, nil
+	}
+
+	query.Set("api-version", "2018-02-01")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	return req, nil
+}
@@ -0,0 +1,146 @@
+// Package azure has the azure endpoints, authentication and the helpers
+// shared by the requests to the azure apis.
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client timeout covers the whole request including reading the response
+var Client = &http.Client{Timeout: 30 * time.Second}
+
+type Config struct {
+	// Cloud is public (default), usgov or china
+	Cloud string
+	// the endpoint overrides, e.g. for private endpoints
+	TTSEndpoint        string
+	VoiceEndpoint      string
+	STTEndpoint        string
+	APIEndpoint        string
+	TranslatorEndpoint string
+	AuthorityHost      string
+
+	// Auth is key (default), token or aad
+	Auth             string
+	SpeechResourceID string
+
+	Timeout          time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// Setup selects the cloud and applies the settings.
+func Setup(cfg Config) error {
+	if cfg.Cloud != "" {
+		selected, ok := Clouds[cfg.Cloud]
+		if !ok {
+			return fmt.Errorf("unknown cloud %q", cfg.Cloud)
+		}
+		Cloud = selected
+	}
+	for value, endpoint := range map[string]*string{
+		cfg.TTSEndpoint:        &Cloud.TTS,
+		cfg.VoiceEndpoint:      &Cloud.Voice,
+		cfg.STTEndpoint:        &Cloud.STT,
+		cfg.APIEndpoint:        &Cloud.API,
+		cfg.TranslatorEndpoint: &Cloud.Translator,
+		cfg.AuthorityHost:      &Cloud.Authority,
+	} {
+		if value != "" {
+			*endpoint = strings.TrimSuffix(value, "/")
+		}
+	}
+
+	switch cfg.Auth {
+	case "", "key", "token":
+	case "aad":
+		if cfg.SpeechResourceID == "" {
+			return fmt.Errorf("speech resource id is required for aad auth")
+		}
+	default:
+		return fmt.Errorf("unknown auth %q", cfg.Auth)
+	}
+	auth = cfg.Auth
+	speechResourceID = cfg.SpeechResourceID
+
+	if cfg.Timeout > 0 {
+		Client.Timeout = cfg.Timeout
+	}
+	breakerThreshold = cfg.BreakerThreshold
+	if cfg.BreakerCooldown > 0 {
+		breakerCooldown = cfg.BreakerCooldown
+	}
+	return nil
+}
+
+type Error struct {
+	StatusCode int
+	// Message is the reason azure gave in the response body, if any
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Azure returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Azure returned %d: %s", e.StatusCode, e.Message)
+}
+
+// ResponseError returns the error of a failed response with the reason
+// from its body, the caller still has to close the body.
+func ResponseError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Message:    errorMessage(body),
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// errorMessage returns the message of an azure error body, the apis
+// respond with {"error": {"message": "..."}}, {"message": "..."} or plain text.
+func errorMessage(body []byte) string {
+	var response struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &response) == nil {
+		if response.Error.Message != "" {
+			return response.Error.Message
+		}
+		return response.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// IsRetryable reports whether a request that failed with the status can be retried.
+func IsRetryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,196 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const blobVersion = "2021-08-06"
+
+// Container is an azure storage container, it's used by the azureblob
+// cache backend and the backups.
+type Container struct {
+	url *url.URL
+	// token returns the azure ad token, nil when the url has a sas token
+	token func(ctx context.Context) (string, error)
+}
+
+// Blob is a blob returned by Container.List.
+type Blob struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		ContentLength int64 `xml:"Content-Length"`
+	} `xml:"Properties"`
+	Metadata struct {
+		Expires string `xml:"expires"`
+	} `xml:"Metadata"`
+}
+
+// NewContainer uses the sas token of containerURL if it has one and azure
+// ad tokens otherwise.
+func NewContainer(containerURL string) (*Container, error) {
+	u, err := url.Parse(strings.TrimSuffix(containerURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	container := &Container{url: u}
+	if u.RawQuery == "" {
+		container.token = StorageToken
+	}
+	return container, nil
+}
+
+// Get returns nil without an error when the blob doesn't exist.
+func (c *Container) Get(ctx context.Context, name string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, name, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, blobError(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Open streams the blob, the caller has to close it.
+func (c *Container) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, name, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, blobError(resp)
+	}
+	return resp.Body, nil
+}
+
+// Put writes a block blob, header can set its content type and metadata.
+func (c *Container) Put(ctx context.Context, name string, body io.Reader, size int64, header http.Header) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("x-ms-blob-type", "BlockBlob")
+	resp, err := c.do(ctx, http.MethodPut, name, nil, body, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return blobError(resp)
+	}
+	return nil
+}
+
+// Delete doesn't fail when the blob doesn't exist.
+func (c *Container) Delete(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, name, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		return blobError(resp)
+	}
+	return nil
+}
+
+// List returns a page of the blobs that start with prefix and the marker
+// of the next page, which is empty on the last one.
+func (c *Container) List(ctx context.Context, prefix, marker string, maxResults int) ([]Blob, string, error) {
+	query := url.Values{
+		"restype":    {"container"},
+		"comp":       {"list"},
+		"include":    {"metadata"},
+		"maxresults": {strconv.Itoa(maxResults)},
+	}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if marker != "" {
+		query.Set("marker", marker)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "", query, nil, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", blobError(resp)
+	}
+
+	var result struct {
+		Blobs      []Blob `xml:"Blobs>Blob"`
+		NextMarker string `xml:"NextMarker"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", err
+	}
+	return result.Blobs, result.NextMarker, nil
+}
+
+// do sends a request for the blob name, or the container itself when name
+// is empty, keeping the sas token of the container url.
+func (c *Container) do(ctx context.Context, method, name string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u := *c.url
+	if name != "" {
+		u.Path += "/" + name
+		u.RawPath = ""
+	}
+	if len(query) > 0 {
+		values := u.Query()
+		for key, value := range query {
+			values[key] = value
+		}
+		u.RawQuery = values.Encode()
+	}
+
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("x-ms-version", blobVersion)
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := storageClient.Do(req)
+	// don't log the sas token
+	if urlErr, ok := err.(*url.Error); ok {
+		u.RawQuery = ""
+		urlErr.URL = u.String()
+	}
+	return resp, err
+}
+
+// storageClient has no timeout, large blobs can take longer than the
+// speech requests
+var storageClient = &http.Client{}
+
+func blobError(resp *http.Response) error {
+	if code := resp.Header.Get("x-ms-error-code"); code != "" {
+		return fmt.Errorf("azure storage returned %d: %s", resp.StatusCode, code)
+	}
+	return fmt.Errorf("azure storage returned %d", resp.StatusCode)
+}
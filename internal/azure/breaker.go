@@ -0,0 +1,99 @@
+package azure
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var breakerThreshold = 5
+var breakerCooldown = 30 * time.Second
+
+// BreakerCooldown is how long the breaker stays open.
+func BreakerCooldown() time.Duration {
+	return breakerCooldown
+}
+
+var ErrCircuitOpen = errors.New("azure is unavailable, try again later")
+
+// Breaker stops calls to Azure after consecutive failures and lets
+// a single probe request through once the cooldown has passed.
+type Breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var breakersMu sync.Mutex
+var breakers = map[string]*Breaker{}
+
+// BreakerFor returns the circuit breaker of the region.
+func BreakerFor(region string) *Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[region]
+	if !ok {
+		b = &Breaker{}
+		breakers[region] = b
+	}
+	return b
+}
+
+// Allow reports whether a request can be sent, the outcome has to be
+// passed to Record or Release.
+func (b *Breaker) Allow() bool {
+	if breakerThreshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Record counts the failure when err means azure is down.
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+
+	if !IsDown(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
+
+// Release ends a probe without an outcome, e.g. when the client went away.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// IsDown reports whether err means Azure is failing,
+// client errors like an invalid key don't count.
+func IsDown(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	var azureErr *Error
+	if errors.As(err, &azureErr) {
+		return azureErr.StatusCode >= 500
+	}
+	return true
+}
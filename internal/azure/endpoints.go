@@ -0,0 +1,60 @@
+package azure
+
+import "strings"
+
+// Endpoints has the endpoints of an azure cloud, {region} is replaced
+// with the region of the request
+type Endpoints struct {
+	TTS        string
+	Voice      string
+	STT        string
+	API        string
+	Translator string
+	Authority  string
+	Speech     string
+	Vault      string
+	Storage    string
+}
+
+var Clouds = map[string]Endpoints{
+	"public": {
+		TTS:        "https://{region}.tts.speech.microsoft.com",
+		Voice:      "https://{region}.voice.speech.microsoft.com",
+		STT:        "https://{region}.stt.speech.microsoft.com",
+		API:        "https://{region}.api.cognitive.microsoft.com",
+		Translator: "https://api.cognitive.microsofttranslator.com",
+		Authority:  "https://login.microsoftonline.com",
+		Speech:     "https://cognitiveservices.azure.com",
+		Vault:      "https://vault.azure.net",
+		Storage:    "https://storage.azure.com",
+	},
+	"usgov": {
+		TTS:        "https://{region}.tts.speech.azure.us",
+		Voice:      "https://{region}.voice.speech.azure.us",
+		STT:        "https://{region}.stt.speech.azure.us",
+		API:        "https://{region}.api.cognitive.microsoft.us",
+		Translator: "https://api.cognitive.microsofttranslator.us",
+		Authority:  "https://login.microsoftonline.us",
+		Speech:     "https://cognitiveservices.azure.us",
+		Vault:      "https://vault.usgovcloudapi.net",
+		Storage:    "https://storage.azure.com",
+	},
+	"china": {
+		TTS:        "https://{region}.tts.speech.azure.cn",
+		Voice:      "https://{region}.voice.speech.azure.cn",
+		STT:        "https://{region}.stt.speech.azure.cn",
+		API:        "https://{region}.api.cognitive.azure.cn",
+		Translator: "https://api.translator.azure.cn",
+		Authority:  "https://login.chinacloudapi.cn",
+		Speech:     "https://cognitiveservices.azure.cn",
+		Vault:      "https://vault.azure.cn",
+		Storage:    "https://storage.azure.com",
+	},
+}
+
+// Cloud has the endpoints of the selected cloud with the overrides applied
+var Cloud = Clouds["public"]
+
+func RegionEndpoint(template, region string) string {
+	return strings.ReplaceAll(template, "{region}", region)
+}
@@ -0,0 +1,45 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyVaultSecret reads the secret from the key vault with an azure ad token.
+func KeyVaultSecret(ctx context.Context, vaultURL, name string) (string, error) {
+	token, err := aadToken(ctx, Cloud.Vault)
+	if err != nil {
+		return "", err
+	}
+
+	secretURL := fmt.Sprintf("%s/secrets/%s?api-version=7.4", strings.TrimSuffix(vaultURL, "/"), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("key vault returned %d", resp.StatusCode)
+	}
+
+	var secret struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	if secret.Value == "" {
+		return "", fmt.Errorf("secret %s is empty", name)
+	}
+	return secret.Value, nil
+}
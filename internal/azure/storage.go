@@ -0,0 +1,9 @@
+package azure
+
+import "context"
+
+// StorageToken returns an azure ad token for blob storage, used by the
+// azureblob cache backend when its url has no sas token.
+func StorageToken(ctx context.Context) (string, error) {
+	return aadToken(ctx, Cloud.Storage)
+}
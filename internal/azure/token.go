@@ -0,0 +1,108 @@
+package azure
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// auth is how requests to azure are authenticated: "key" sends the
+// subscription key, "token" exchanges it for bearer tokens and "aad" uses
+// azure ad tokens when the request has no key of its own
+var auth string
+
+// azure tokens are valid for 10 minutes, they're refreshed in the background
+// after tokenRefreshAfter and not used anymore after tokenMaxAge
+const tokenRefreshAfter = 8 * time.Minute
+const tokenMaxAge = 9 * time.Minute
+
+type cachedToken struct {
+	value  string
+	issued time.Time
+}
+
+var tokensMu sync.Mutex
+var tokens = map[string]cachedToken{}
+var tokenGroup singleflight.Group
+
+// SetAuth sets the authentication header for a request to azure.
+func SetAuth(ctx context.Context, header http.Header, region, key string) error {
+	if auth == "aad" && key == "" {
+		authorization, err := aadAuthorization(ctx)
+		if err != nil {
+			return err
+		}
+		header.Set("Authorization", authorization)
+		return nil
+	}
+	if auth != "token" {
+		header.Set("Ocp-Apim-Subscription-Key", key)
+		return nil
+	}
+
+	token, err := azureToken(ctx, region, key)
+	if err != nil {
+		return err
+	}
+	header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func azureToken(ctx context.Context, region, key string) (string, error) {
+	id := region + "\x00" + key
+	tokensMu.Lock()
+	token, ok := tokens[id]
+	tokensMu.Unlock()
+
+	age := time.Since(token.issued)
+	if ok && age < tokenMaxAge {
+		if age > tokenRefreshAfter {
+			go func() {
+				if _, err := fetchToken(context.WithoutCancel(ctx), id, region, key); err != nil {
+					slog.Warn("Failed to refresh azure token", "region", region, "error", err)
+				}
+			}()
+		}
+		return token.value, nil
+	}
+	return fetchToken(ctx, id, region, key)
+}
+
+// fetchToken exchanges the key for a token, concurrent calls share the request.
+func fetchToken(ctx context.Context, id, region, key string) (string, error) {
+	val, err, _ := tokenGroup.Do(id, func() (interface{}, error) {
+		url := RegionEndpoint(Cloud.API, region) + "/sts/v1.0/issueToken"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Ocp-Apim-Subscription-Key", key)
+
+		resp, err := Client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", ResponseError(resp)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+
+		tokensMu.Lock()
+		tokens[id] = cachedToken{value: string(body), issued: time.Now()}
+		tokensMu.Unlock()
+		return string(body), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return val.(string), nil
+}
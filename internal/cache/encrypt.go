@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// sealedPrefix marks encrypted data, data without it was written before
+// the encryption was enabled and is read as it is
+var sealedPrefix = []byte("ASC\x01")
+
+// aead encrypts the persisted audio and metadata, nil when it's disabled
+var aead cipher.AEAD
+
+var errNoEncryptionKey = errors.New("data is encrypted but no encryption key is set")
+
+// SetEncryptionKey enables AES-GCM encryption of the entries written by the
+// stores and of the cache file. The key has to be 32 bytes, nil disables it.
+func SetEncryptionKey(key []byte) error {
+	if key == nil {
+		aead = nil
+		return nil
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("encryption key has to be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	aead = gcm
+	return nil
+}
+
+// EncryptionEnabled reports whether Seal encrypts the data.
+func EncryptionEnabled() bool {
+	return aead != nil
+}
+
+// Seal encrypts data when encryption is enabled.
+func Seal(data []byte) []byte {
+	if aead == nil {
+		return data
+	}
+	out := make([]byte, len(sealedPrefix)+aead.NonceSize(), len(sealedPrefix)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, sealedPrefix)
+	nonce := out[len(sealedPrefix):]
+	rand.Read(nonce)
+	return aead.Seal(out, nonce, data, sealedPrefix)
+}
+
+// Unseal decrypts data written by Seal, unencrypted data is returned as it is.
+func Unseal(data []byte) ([]byte, error) {
+	if !Sealed(data) {
+		return data, nil
+	}
+	if aead == nil {
+		return nil, errNoEncryptionKey
+	}
+	data = data[len(sealedPrefix):]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, sealedPrefix)
+}
+
+// Sealed reports whether data was encrypted by Seal.
+func Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedPrefix)
+}
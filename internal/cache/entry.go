@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type Entry struct {
+	Audio []byte
+	Type  string
+	// Blob and Size are set when the audio is stored on disk instead of Audio
+	Blob string
+	Size int64
+	// load reads the audio of entries that are listed without it, Size is
+	// set for them too
+	load func() ([]byte, error)
+
+	Text     string
+	Voice    string
+	Language string
+	// Namespace is the tenant or namespace the entry was cached for
+	Namespace string
+	Created   time.Time
+	// Expires is when the entry expires, zero if it never does
+	Expires time.Time
+	// TTL is the ttl the entry was stored with, to extend it on access
+	TTL time.Duration
+
+	// HasEvents is set when the audio was synthesized over the websocket api
+	HasEvents bool
+	Words     []WordBoundary
+	Visemes   []Viseme
+}
+
+// WordBoundary is the position of a spoken word in the audio, in milliseconds.
+type WordBoundary struct {
+	Text     string `json:"text"`
+	Offset   int64  `json:"offset"`
+	Duration int64  `json:"duration"`
+}
+
+// Viseme is the mouth position at a point of the audio, offset in milliseconds.
+type Viseme struct {
+	ID     int   `json:"id"`
+	Offset int64 `json:"offset"`
+}
+
+// WriteFileAtomic writes to a temporary file and renames it to path once
+// the write succeeded, so readers never see a partial file.
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,75 @@
+// Package cache has the storage backends for synthesized audio.
+package cache
+
+import (
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Store is a storage backend for synthesized audio.
+// A ttl of 0 means the entry never expires.
+type Store interface {
+	Get(key string) (Entry, bool)
+	Set(key string, entry Entry, ttl time.Duration)
+	Delete(key string)
+	Items() map[string]Entry
+	Stats() Stats
+}
+
+type Stats struct {
+	Items     int
+	Bytes     int64
+	Evictions int64
+}
+
+type memoryStore struct {
+	cache *cache.Cache
+}
+
+// NewMemory returns an in-memory store, expired entries are removed
+// every cleanupInterval.
+func NewMemory(defaultExpiration, cleanupInterval time.Duration) Store {
+	return newMemoryStore(defaultExpiration, cleanupInterval)
+}
+
+func newMemoryStore(defaultExpiration, cleanupInterval time.Duration) *memoryStore {
+	return &memoryStore{cache: cache.New(defaultExpiration, cleanupInterval)}
+}
+
+func (s *memoryStore) Get(key string) (Entry, bool) {
+	val, ok := s.cache.Get(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return val.(Entry), true
+}
+
+func (s *memoryStore) Set(key string, entry Entry, ttl time.Duration) {
+	if ttl == 0 {
+		ttl = cache.NoExpiration
+	}
+	s.cache.Set(key, entry, ttl)
+}
+
+func (s *memoryStore) Delete(key string) {
+	s.cache.Delete(key)
+}
+
+func (s *memoryStore) Items() map[string]Entry {
+	items := s.cache.Items()
+	entries := make(map[string]Entry, len(items))
+	for key, item := range items {
+		entries[key] = item.Object.(Entry)
+	}
+	return entries
+}
+
+func (s *memoryStore) Stats() Stats {
+	stats := Stats{}
+	for key, item := range s.cache.Items() {
+		stats.Items++
+		stats.Bytes += Size(key, item.Object.(Entry))
+	}
+	return stats
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+// azureBlobStore writes the audio of every entry to an azure storage
+// container as audio/<key> and the rest of the entry as meta/<key>.json,
+// so the cache isn't limited by the memory and is shared between instances.
+type azureBlobStore struct {
+	container *azure.Container
+}
+
+// NewAzureBlob uses the container, which has to exist. Expired entries are
+// removed every cleanupInterval.
+func NewAzureBlob(container *azure.Container, cleanupInterval time.Duration) (Store, error) {
+	// fail early when the container doesn't exist or can't be accessed
+	if _, _, err := container.List(context.Background(), "meta/", "", 1); err != nil {
+		return nil, err
+	}
+	s := &azureBlobStore{container: container}
+	go s.cleanup(cleanupInterval)
+	return s, nil
+}
+
+func (s *azureBlobStore) Get(key string) (Entry, bool) {
+	entry, ok := s.meta(key)
+	if !ok {
+		return Entry{}, false
+	}
+	audio, err := s.audio(key)
+	if err != nil {
+		slog.Error("Failed to read azure blob audio", "key", key, "error", err)
+		return Entry{}, false
+	}
+	if audio == nil {
+		return Entry{}, false
+	}
+	entry.Audio = audio
+	return entry, true
+}
+
+// meta returns the entry without its audio.
+func (s *azureBlobStore) meta(key string) (Entry, bool) {
+	data, err := s.container.Get(context.Background(), "meta/"+key+".json")
+	if err != nil {
+		slog.Error("Failed to read azure blob entry", "error", err)
+		return Entry{}, false
+	}
+	if data == nil {
+		return Entry{}, false
+	}
+	data, err = Unseal(data)
+	if err != nil {
+		slog.Error("Failed to decrypt azure blob entry", "key", key, "error", err)
+		return Entry{}, false
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		slog.Error("Failed to decode azure blob entry", "key", key, "error", err)
+		return Entry{}, false
+	}
+	if expired(entry) {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// audio returns the decrypted audio of the entry, nil when it doesn't exist.
+func (s *azureBlobStore) audio(key string) ([]byte, error) {
+	audio, err := s.container.Get(context.Background(), "audio/"+key)
+	if err != nil || audio == nil {
+		return nil, err
+	}
+	return Unseal(audio)
+}
+
+func (s *azureBlobStore) Set(key string, entry Entry, ttl time.Duration) {
+	if ttl > 0 && entry.Expires.IsZero() {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	audio := Seal(entry.Audio)
+	// the size is kept in the metadata so the audio isn't read to list it
+	entry.Size, entry.Audio = int64(len(entry.Audio)), nil
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Failed to encode azure blob entry", "error", err)
+		return
+	}
+	meta = Seal(meta)
+
+	// the metadata is written last, entries without it don't exist
+	ctx := context.Background()
+	header := http.Header{"Content-Type": {entry.Type}}
+	if err := s.container.Put(ctx, "audio/"+key, bytes.NewReader(audio), int64(len(audio)), header); err != nil {
+		slog.Error("Failed to write azure blob audio", "error", err)
+		return
+	}
+	header = http.Header{"Content-Type": {"application/json"}}
+	if !entry.Expires.IsZero() {
+		header.Set("x-ms-meta-expires", entry.Expires.UTC().Format(time.RFC3339))
+	}
+	if err := s.container.Put(ctx, "meta/"+key+".json", bytes.NewReader(meta), int64(len(meta)), header); err != nil {
+		slog.Error("Failed to write azure blob entry", "error", err)
+	}
+}
+
+func (s *azureBlobStore) Delete(key string) {
+	ctx := context.Background()
+	for _, name := range []string{"meta/" + key + ".json", "audio/" + key} {
+		if err := s.container.Delete(ctx, name); err != nil {
+			slog.Error("Failed to delete azure blob", "name", name, "error", err)
+		}
+	}
+}
+
+// Items only reads the metadata, the audio is read when the entry is opened.
+func (s *azureBlobStore) Items() map[string]Entry {
+	entries := make(map[string]Entry)
+	sizes := make(map[string]int64)
+	s.each("", func(blob azure.Blob) {
+		if key, ok := strings.CutPrefix(blob.Name, "audio/"); ok {
+			sizes[key] = blob.Properties.ContentLength
+			return
+		}
+		key, ok := strings.CutPrefix(blob.Name, "meta/")
+		if !ok {
+			return
+		}
+		key = strings.TrimSuffix(key, ".json")
+		if entry, ok := s.meta(key); ok {
+			entry.load = s.loader(key)
+			entries[key] = entry
+		}
+	})
+	// entries written before the size was kept use the size of the blob
+	for key, entry := range entries {
+		if entry.Size == 0 {
+			entry.Size = sizes[key]
+			entries[key] = entry
+		}
+	}
+	return entries
+}
+
+func (s *azureBlobStore) loader(key string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		audio, err := s.audio(key)
+		if err == nil && audio == nil {
+			err = fmt.Errorf("audio of %s not found", key)
+		}
+		return audio, err
+	}
+}
+
+func (s *azureBlobStore) Stats() Stats {
+	stats := Stats{}
+	s.each("", func(blob azure.Blob) {
+		if strings.HasPrefix(blob.Name, "meta/") {
+			stats.Items++
+		}
+		stats.Bytes += blob.Properties.ContentLength
+	})
+	return stats
+}
+
+// each calls fn with every blob that starts with prefix.
+func (s *azureBlobStore) each(prefix string, fn func(blob azure.Blob)) {
+	ctx := context.Background()
+	marker := ""
+	for {
+		blobs, next, err := s.container.List(ctx, prefix, marker, 5000)
+		if err != nil {
+			slog.Error("Failed to list azure blobs", "error", err)
+			return
+		}
+		for _, blob := range blobs {
+			fn(blob)
+		}
+		if next == "" {
+			return
+		}
+		marker = next
+	}
+}
+
+// cleanup removes the expired entries every interval, the expiry is kept
+// in the blob metadata so the entries don't have to be downloaded.
+func (s *azureBlobStore) cleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		var keys []string
+		s.each("meta/", func(blob azure.Blob) {
+			expires, err := time.Parse(time.RFC3339, blob.Metadata.Expires)
+			if err == nil && time.Now().After(expires) {
+				keys = append(keys, strings.TrimSuffix(strings.TrimPrefix(blob.Name, "meta/"), ".json"))
+			}
+		})
+		for _, key := range keys {
+			s.Delete(key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nerijusdu/azure-speech-cache/internal/azure"
+)
+
+// fakeContainer is enough of the blob api for the azureblob store, it
+// counts how often the audio is downloaded.
+type fakeContainer struct {
+	mu         sync.Mutex
+	blobs      map[string][]byte
+	audioReads int
+}
+
+func (f *fakeContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/container/")
+	switch {
+	case r.URL.Query().Get("comp") == "list":
+		var result struct {
+			XMLName xml.Name     `xml:"EnumerationResults"`
+			Blobs   []azure.Blob `xml:"Blobs>Blob"`
+		}
+		for blobName, data := range f.blobs {
+			if strings.HasPrefix(blobName, r.URL.Query().Get("prefix")) {
+				blob := azure.Blob{Name: blobName}
+				blob.Properties.ContentLength = int64(len(data))
+				result.Blobs = append(result.Blobs, blob)
+			}
+		}
+		sort.Slice(result.Blobs, func(i, j int) bool { return result.Blobs[i].Name < result.Blobs[j].Name })
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet:
+		data, ok := f.blobs[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.HasPrefix(name, "audio/") {
+			f.audioReads++
+		}
+		w.Write(data)
+	case r.Method == http.MethodPut:
+		f.blobs[name], _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestAzureBlobItemsWithoutAudio(t *testing.T) {
+	fake := &fakeContainer{blobs: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	container, err := azure.NewContainer(server.URL + "/container?sv=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewAzureBlob(container, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Set("key", Entry{Audio: []byte("audio"), Type: "audio/mpeg", Text: "hello"}, 0)
+	items := store.Items()
+	entry, ok := items["key"]
+	if !ok || entry.Text != "hello" {
+		t.Fatalf("Items() = %+v, want the entry", items)
+	}
+	if fake.audioReads != 0 {
+		t.Errorf("Items() downloaded the audio %d times", fake.audioReads)
+	}
+	if size := Size("key", entry); size != int64(len("key")+len("audio")) {
+		t.Errorf("Size() = %d, want the size of the key and the audio", size)
+	}
+
+	audio, err := Open(entry, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audio.Close()
+	data, _ := io.ReadAll(audio)
+	if string(data) != "audio" || fake.audioReads != 1 {
+		t.Errorf("Open() read %q with %d downloads, want the audio once", data, fake.audioReads)
+	}
+}
@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("entries")
+
+// boltStore writes every entry to a bbolt file as it is added, so the
+// cache doesn't have to be saved as a whole.
+type boltStore struct {
+	db *bolt.DB
+	// items and bytes are counted as entries are written so Stats doesn't
+	// have to walk the file
+	items atomic.Int64
+	bytes atomic.Int64
+}
+
+// NewBolt opens or creates the bbolt file at path, expired entries are
+// removed every cleanupInterval.
+func NewBolt(path string, cleanupInterval time.Duration) (Store, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &boltStore{db: db}
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(key, value []byte) error {
+			s.items.Add(1)
+			s.bytes.Add(int64(len(key) + len(value)))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	go s.cleanup(cleanupInterval)
+	return s, nil
+}
+
+func (s *boltStore) Get(key string) (Entry, bool) {
+	var entry Entry
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		data, err := Unseal(data)
+		if err != nil {
+			return err
+		}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+			return err
+		}
+		ok = !expired(entry)
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to read bolt entry", "error", err)
+		return Entry{}, false
+	}
+	return entry, ok
+}
+
+func (s *boltStore) Set(key string, entry Entry, ttl time.Duration) {
+	if ttl > 0 && entry.Expires.IsZero() {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(entry); err != nil {
+		slog.Error("Failed to encode bolt entry", "error", err)
+		return
+	}
+
+	value := Seal(buffer.Bytes())
+	var old []byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		old = bucket.Get([]byte(key))
+		return bucket.Put([]byte(key), value)
+	})
+	if err != nil {
+		slog.Error("Failed to write bolt entry", "error", err)
+		return
+	}
+	if old == nil {
+		s.items.Add(1)
+		s.bytes.Add(int64(len(key)))
+	}
+	s.bytes.Add(int64(len(value) - len(old)))
+}
+
+func (s *boltStore) Delete(key string) {
+	var size int
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if old := bucket.Get([]byte(key)); old != nil {
+			size, found = len(key)+len(old), true
+		}
+		return bucket.Delete([]byte(key))
+	})
+	if err != nil {
+		slog.Error("Failed to delete bolt entry", "error", err)
+		return
+	}
+	if found {
+		s.items.Add(-1)
+		s.bytes.Add(-int64(size))
+	}
+}
+
+func (s *boltStore) Items() map[string]Entry {
+	entries := make(map[string]Entry)
+	s.each(func(key string, entry Entry) {
+		if !expired(entry) {
+			entries[key] = entry
+		}
+	})
+	return entries
+}
+
+func (s *boltStore) Stats() Stats {
+	return Stats{Items: int(s.items.Load()), Bytes: s.bytes.Load()}
+}
+
+func (s *boltStore) each(fn func(key string, entry Entry)) {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(key, value []byte) error {
+			var entry Entry
+			value, err := Unseal(value)
+			if err == nil {
+				err = gob.NewDecoder(bytes.NewReader(value)).Decode(&entry)
+			}
+			if err != nil {
+				slog.Warn("Skipping corrupted bolt entry", "key", string(key), "error", err)
+				return nil
+			}
+			fn(string(key), entry)
+			return nil
+		})
+	})
+	if err != nil {
+		slog.Error("Failed to read bolt entries", "error", err)
+	}
+}
+
+// cleanup removes the expired entries every interval.
+func (s *boltStore) cleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		var keys []string
+		s.each(func(key string, entry Entry) {
+			if expired(entry) {
+				keys = append(keys, key)
+			}
+		})
+		for _, key := range keys {
+			s.Delete(key)
+		}
+	}
+}
+
+func expired(entry Entry) bool {
+	return !entry.Expires.IsZero() && time.Now().After(entry.Expires)
+}
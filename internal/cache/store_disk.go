@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// diskStore keeps entry metadata in memory and writes the audio to
+// content-addressed files in dir.
+type diskStore struct {
+	meta *memoryStore
+	dir  string
+}
+
+// NewDisk returns a store that writes the audio to files in dir.
+func NewDisk(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &diskStore{meta: newMemoryStore(cache.NoExpiration, 10*time.Minute), dir: dir}
+	// also called for expired entries, so their audio doesn't stay behind
+	s.meta.cache.OnEvicted(func(key string, value interface{}) {
+		s.removeBlob(value.(Entry))
+	})
+	return s, nil
+}
+
+func (s *diskStore) Get(key string) (Entry, bool) {
+	return s.meta.Get(key)
+}
+
+func (s *diskStore) Set(key string, entry Entry, ttl time.Duration) {
+	if entry.Audio != nil {
+		sum := sha256.Sum256(entry.Audio)
+		hash := hex.EncodeToString(sum[:])
+		if err := s.writeBlob(hash, entry.Audio); err != nil {
+			slog.Error("Failed to write audio blob", "error", err)
+			return
+		}
+		entry.Blob = hash
+		entry.Size = int64(len(entry.Audio))
+		entry.Audio = nil
+	}
+	s.meta.Set(key, entry, ttl)
+}
+
+func (s *diskStore) Delete(key string) {
+	s.meta.Delete(key)
+}
+
+// removeBlob removes the audio file of a deleted entry, unless another
+// entry has the same audio.
+func (s *diskStore) removeBlob(entry Entry) {
+	if entry.Blob == "" {
+		return
+	}
+	for _, other := range s.meta.Items() {
+		if other.Blob == entry.Blob {
+			return
+		}
+	}
+	if err := os.Remove(filepath.Join(s.dir, entry.Blob)); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove audio blob", "error", err)
+	}
+}
+
+func (s *diskStore) Items() map[string]Entry {
+	return s.meta.Items()
+}
+
+func (s *diskStore) Stats() Stats {
+	stats := Stats{}
+	for key, entry := range s.meta.Items() {
+		stats.Items++
+		stats.Bytes += Size(key, entry)
+	}
+	return stats
+}
+
+func (s *diskStore) writeBlob(hash string, audio []byte) error {
+	path := filepath.Join(s.dir, hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(Seal(audio))
+		return err
+	})
+}
+
+// Open returns a reader over the entry audio, streaming it from blobDir
+// when the entry is stored as a blob. Encrypted blobs are read into memory.
+func Open(entry Entry, blobDir string) (io.ReadSeekCloser, error) {
+	if entry.Blob == "" {
+		entry, err := Loaded(entry)
+		if err != nil {
+			return nil, err
+		}
+		return nopCloser{bytes.NewReader(entry.Audio)}, nil
+	}
+	file, err := os.Open(filepath.Join(blobDir, entry.Blob))
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, len(sealedPrefix))
+	n, _ := io.ReadFull(file, prefix)
+	if !Sealed(prefix[:n]) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	defer file.Close()
+	rest, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := Unseal(append(prefix, rest...))
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(audio)}, nil
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Loaded returns the entry with its audio, reading it from the backend when
+// the entry was listed without it.
+func Loaded(entry Entry) (Entry, error) {
+	if entry.load == nil {
+		return entry, nil
+	}
+	audio, err := entry.load()
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Audio, entry.Size, entry.load = audio, int64(len(audio)), nil
+	return entry, nil
+}
+
+// Hash is the sha-256 hash of the entry audio in hex. Blobs are named
+// after it, so it's only computed for entries stored in memory.
+func Hash(entry Entry) string {
+	if entry.Blob != "" {
+		return entry.Blob
+	}
+	sum := sha256.Sum256(entry.Audio)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruStore wraps another store and evicts the least recently used entries
+// once the configured item count or byte size is exceeded.
+// A limit of 0 disables it.
+type lruStore struct {
+	inner    Store
+	maxBytes int64
+	maxItems int
+
+	mu        sync.Mutex
+	order     *list.List
+	elements  map[string]*list.Element
+	bytes     int64
+	evictions int64
+}
+
+type lruItem struct {
+	key  string
+	size int64
+}
+
+func NewLRU(inner Store, maxBytes int64, maxItems int) Store {
+	return &lruStore{
+		inner:    inner,
+		maxBytes: maxBytes,
+		maxItems: maxItems,
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (s *lruStore) Get(key string) (Entry, bool) {
+	entry, ok := s.inner.Get(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, tracked := s.elements[key]; tracked {
+		if ok {
+			s.order.MoveToFront(el)
+		} else {
+			s.remove(el)
+		}
+	}
+	return entry, ok
+}
+
+func (s *lruStore) Set(key string, entry Entry, ttl time.Duration) {
+	s.inner.Set(key, entry, ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.elements[key]; ok {
+		s.remove(el)
+	}
+	size := Size(key, entry)
+	s.elements[key] = s.order.PushFront(&lruItem{key: key, size: size})
+	s.bytes += size
+
+	for s.order.Len() > 1 && s.overLimit() {
+		el := s.order.Back()
+		s.inner.Delete(el.Value.(*lruItem).key)
+		s.remove(el)
+		s.evictions++
+	}
+}
+
+func (s *lruStore) Delete(key string) {
+	s.inner.Delete(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.elements[key]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *lruStore) Items() map[string]Entry {
+	return s.inner.Items()
+}
+
+func (s *lruStore) Stats() Stats {
+	stats := s.inner.Stats()
+	s.mu.Lock()
+	stats.Evictions = s.evictions
+	s.mu.Unlock()
+	return stats
+}
+
+func (s *lruStore) overLimit() bool {
+	return (s.maxItems > 0 && s.order.Len() > s.maxItems) ||
+		(s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+func (s *lruStore) remove(el *list.Element) {
+	item := el.Value.(*lruItem)
+	s.order.Remove(el)
+	delete(s.elements, item.key)
+	s.bytes -= item.size
+}
+
+// Size is the size of the entry audio and key in bytes.
+func Size(key string, entry Entry) int64 {
+	size := int64(len(entry.Audio))
+	if entry.Blob != "" || entry.load != nil {
+		size = entry.Size
+	}
+	return size + int64(len(key))
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client, prefix string) Store {
+	return &redisStore{client: client, prefix: prefix}
+}
+
+func (s *redisStore) Get(key string) (Entry, bool) {
+	data, err := s.client.Get(context.Background(), s.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Error("Failed to read from redis", "error", err)
+		}
+		return Entry{}, false
+	}
+
+	data, err = Unseal(data)
+	if err != nil {
+		slog.Error("Failed to decrypt redis entry", "error", err)
+		return Entry{}, false
+	}
+	var entry Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		slog.Error("Failed to decode redis entry", "error", err)
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+func (s *redisStore) Set(key string, entry Entry, ttl time.Duration) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(entry); err != nil {
+		slog.Error("Failed to encode redis entry", "error", err)
+		return
+	}
+
+	err := s.client.Set(context.Background(), s.prefix+key, Seal(buffer.Bytes()), ttl).Err()
+	if err != nil {
+		slog.Error("Failed to write to redis", "error", err)
+	}
+}
+
+func (s *redisStore) Delete(key string) {
+	err := s.client.Del(context.Background(), s.prefix+key).Err()
+	if err != nil {
+		slog.Error("Failed to delete from redis", "error", err)
+	}
+}
+
+func (s *redisStore) Items() map[string]Entry {
+	entries := make(map[string]Entry)
+	s.scan(func(key string) {
+		if entry, ok := s.Get(key); ok {
+			entries[key] = entry
+		}
+	})
+	return entries
+}
+
+func (s *redisStore) Stats() Stats {
+	stats := Stats{}
+	ctx := context.Background()
+	s.scan(func(key string) {
+		size, err := s.client.StrLen(ctx, s.prefix+key).Result()
+		if err != nil {
+			return
+		}
+		stats.Items++
+		stats.Bytes += size + int64(len(key))
+	})
+	return stats
+}
+
+func (s *redisStore) scan(fn func(key string)) {
+	ctx := context.Background()
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		fn(iter.Val()[len(s.prefix):])
+	}
+	if err := iter.Err(); err != nil {
+		slog.Error("Failed to scan redis keys", "error", err)
+	}
+}
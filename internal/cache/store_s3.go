@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// s3Store writes the audio of every entry to an s3 compatible bucket as
+// <prefix>audio/<key> and the rest of the entry as <prefix>meta/<key>.json.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 uses the bucket, which has to exist. Expired entries are removed
+// every cleanupInterval.
+func NewS3(client *minio.Client, bucket, prefix string, cleanupInterval time.Duration) (Store, error) {
+	ok, err := client.BucketExists(context.Background(), bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("bucket %s doesn't exist", bucket)
+	}
+
+	s := &s3Store{client: client, bucket: bucket, prefix: prefix}
+	go s.cleanup(cleanupInterval)
+	return s, nil
+}
+
+func (s *s3Store) Get(key string) (Entry, bool) {
+	entry, ok := s.meta(key)
+	if !ok {
+		return Entry{}, false
+	}
+	audio, err := s.audio(key)
+	if err != nil {
+		slog.Error("Failed to read s3 audio", "key", key, "error", err)
+		return Entry{}, false
+	}
+	if audio == nil {
+		return Entry{}, false
+	}
+	entry.Audio = audio
+	return entry, true
+}
+
+// meta returns the entry without its audio.
+func (s *s3Store) meta(key string) (Entry, bool) {
+	data, err := s.get(context.Background(), s.metaName(key))
+	if err != nil {
+		slog.Error("Failed to read s3 entry", "error", err)
+		return Entry{}, false
+	}
+	if data == nil {
+		return Entry{}, false
+	}
+	data, err = Unseal(data)
+	if err != nil {
+		slog.Error("Failed to decrypt s3 entry", "key", key, "error", err)
+		return Entry{}, false
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		slog.Error("Failed to decode s3 entry", "key", key, "error", err)
+		return Entry{}, false
+	}
+	if expired(entry) {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// audio returns the decrypted audio of the entry, nil when it doesn't exist.
+func (s *s3Store) audio(key string) ([]byte, error) {
+	audio, err := s.get(context.Background(), s.prefix+"audio/"+key)
+	if err != nil || audio == nil {
+		return nil, err
+	}
+	return Unseal(audio)
+}
+
+func (s *s3Store) Set(key string, entry Entry, ttl time.Duration) {
+	if ttl > 0 && entry.Expires.IsZero() {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	audio := Seal(entry.Audio)
+	// the size is kept in the metadata so the audio isn't read to list it
+	entry.Size, entry.Audio = int64(len(entry.Audio)), nil
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Failed to encode s3 entry", "error", err)
+		return
+	}
+	meta = Seal(meta)
+
+	// the metadata is written last, entries without it don't exist
+	ctx := context.Background()
+	_, err = s.client.PutObject(ctx, s.bucket, s.prefix+"audio/"+key, bytes.NewReader(audio), int64(len(audio)),
+		minio.PutObjectOptions{ContentType: entry.Type})
+	if err != nil {
+		slog.Error("Failed to write s3 audio", "error", err)
+		return
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	if !entry.Expires.IsZero() {
+		opts.UserMetadata = map[string]string{"expires-at": entry.Expires.UTC().Format(time.RFC3339)}
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, s.metaName(key), bytes.NewReader(meta), int64(len(meta)), opts)
+	if err != nil {
+		slog.Error("Failed to write s3 entry", "error", err)
+	}
+}
+
+func (s *s3Store) Delete(key string) {
+	ctx := context.Background()
+	for _, name := range []string{s.metaName(key), s.prefix + "audio/" + key} {
+		if err := s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{}); err != nil {
+			slog.Error("Failed to delete s3 object", "name", name, "error", err)
+		}
+	}
+}
+
+// Items only reads the metadata, the audio is read when the entry is opened.
+func (s *s3Store) Items() map[string]Entry {
+	entries := make(map[string]Entry)
+	sizes := make(map[string]int64)
+	s.each(s.prefix, func(object minio.ObjectInfo) {
+		if key, ok := strings.CutPrefix(object.Key, s.prefix+"audio/"); ok {
+			sizes[key] = object.Size
+			return
+		}
+		if !strings.HasPrefix(object.Key, s.prefix+"meta/") {
+			return
+		}
+		key := s.metaKey(object.Key)
+		if entry, ok := s.meta(key); ok {
+			entry.load = s.loader(key)
+			entries[key] = entry
+		}
+	})
+	// entries written before the size was kept use the size of the object
+	for key, entry := range entries {
+		if entry.Size == 0 {
+			entry.Size = sizes[key]
+			entries[key] = entry
+		}
+	}
+	return entries
+}
+
+func (s *s3Store) loader(key string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		audio, err := s.audio(key)
+		if err == nil && audio == nil {
+			err = fmt.Errorf("audio of %s not found", key)
+		}
+		return audio, err
+	}
+}
+
+func (s *s3Store) Stats() Stats {
+	stats := Stats{}
+	s.each(s.prefix, func(object minio.ObjectInfo) {
+		if strings.HasPrefix(object.Key, s.prefix+"meta/") {
+			stats.Items++
+		}
+		stats.Bytes += object.Size
+	})
+	return stats
+}
+
+// get returns nil without an error when the object doesn't exist.
+func (s *s3Store) get(ctx context.Context, name string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil
+	}
+	return data, err
+}
+
+// each calls fn with every object that starts with prefix.
+func (s *s3Store) each(prefix string, fn func(object minio.ObjectInfo)) {
+	objects := s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+	for object := range objects {
+		if object.Err != nil {
+			slog.Error("Failed to list s3 objects", "error", object.Err)
+			return
+		}
+		fn(object)
+	}
+}
+
+// cleanup removes the expired entries every interval, the expiry is kept
+// in the object metadata so the entries don't have to be downloaded. Not
+// every provider returns the metadata when listing, so it's read per object.
+func (s *s3Store) cleanup(interval time.Duration) {
+	ctx := context.Background()
+	for range time.Tick(interval) {
+		var keys []string
+		s.each(s.prefix+"meta/", func(object minio.ObjectInfo) {
+			info, err := s.client.StatObject(ctx, s.bucket, object.Key, minio.StatObjectOptions{})
+			if err != nil {
+				return
+			}
+			expires, err := time.Parse(time.RFC3339, info.Metadata.Get("X-Amz-Meta-Expires-At"))
+			if err == nil && time.Now().After(expires) {
+				keys = append(keys, s.metaKey(object.Key))
+			}
+		})
+		for _, key := range keys {
+			s.Delete(key)
+		}
+	}
+}
+
+func (s *s3Store) metaName(key string) string {
+	return s.prefix + "meta/" + key + ".json"
+}
+
+func (s *s3Store) metaKey(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, s.prefix+"meta/"), ".json")
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	disk, err := NewDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := NewBolt(filepath.Join(t.TempDir(), "cache.db"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{
+		"memory": NewMemory(0, time.Minute),
+		"disk":   disk,
+		"bolt":   bolt,
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			entry := Entry{Audio: []byte("audio"), Type: "audio/mpeg", Text: "hello", Namespace: "app1", Created: time.Now()}
+			store.Set("key", entry, 0)
+
+			got, ok := store.Get("key")
+			if !ok {
+				t.Fatal("entry not found after Set")
+			}
+			if got.Text != "hello" || got.Type != "audio/mpeg" || got.Namespace != "app1" {
+				t.Errorf("got %+v, want the fields of %+v", got, entry)
+			}
+			audio := entryAudio(t, got, store)
+			if !bytes.Equal(audio, entry.Audio) {
+				t.Errorf("audio = %q, want %q", audio, entry.Audio)
+			}
+			if items := store.Items(); len(items) != 1 {
+				t.Errorf("Items() has %d entries, want 1", len(items))
+			}
+			if stats := store.Stats(); stats.Items != 1 {
+				t.Errorf("Stats().Items = %d, want 1", stats.Items)
+			}
+
+			store.Delete("key")
+			if _, ok := store.Get("key"); ok {
+				t.Error("entry found after Delete")
+			}
+			if stats := store.Stats(); stats.Items != 0 {
+				t.Errorf("Stats().Items = %d after Delete, want 0", stats.Items)
+			}
+		})
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			store.Set("key", Entry{Audio: []byte("audio"), Created: time.Now()}, time.Millisecond)
+			time.Sleep(10 * time.Millisecond)
+			if _, ok := store.Get("key"); ok {
+				t.Error("expired entry was returned")
+			}
+		})
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewLRU(NewMemory(0, time.Minute), 0, 2)
+	store.Set("a", Entry{Audio: []byte("a")}, 0)
+	store.Set("b", Entry{Audio: []byte("b")}, 0)
+	// a is used more recently than b now
+	store.Get("a")
+	store.Set("c", Entry{Audio: []byte("c")}, 0)
+
+	if _, ok := store.Get("b"); ok {
+		t.Error("least recently used entry wasn't evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := store.Get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+	if evictions := store.Stats().Evictions; evictions != 1 {
+		t.Errorf("Stats().Evictions = %d, want 1", evictions)
+	}
+}
+
+// entryAudio returns the audio of the entry, the disk store keeps it in a
+// file next to the metadata.
+func entryAudio(t *testing.T, entry Entry, store Store) []byte {
+	t.Helper()
+	dir := ""
+	if disk, ok := store.(*diskStore); ok {
+		dir = disk.dir
+	}
+	audio, err := Open(entry, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audio.Close()
+	data, err := io.ReadAll(audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBoltStatsCounted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	store, err := NewBolt(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("a", Entry{Audio: []byte("audio")}, 0)
+	store.Set("a", Entry{Audio: []byte("longer audio")}, 0)
+	store.Set("b", Entry{Audio: []byte("audio")}, 0)
+	store.Delete("b")
+	store.Delete("missing")
+	want := store.Stats()
+	if want.Items != 1 || want.Bytes <= 0 {
+		t.Fatalf("Stats() = %+v, want 1 item", want)
+	}
+	store.(*boltStore).db.Close()
+
+	// the counts are read from the file again when it's opened
+	reopened, err := NewBolt(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.(*boltStore).db.Close()
+	if got := reopened.Stats(); got != want {
+		t.Errorf("Stats() = %+v after reopening, want %+v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"io"
+	"log/slog"
+	"time"
+)
+
+// tieredStore keeps the most recently used entries of a slower store in
+// memory. Entries are promoted to memory when they're read, the slower
+// store still has all of them.
+type tieredStore struct {
+	hot     Store
+	cold    Store
+	blobDir string
+}
+
+// NewTiered keeps up to maxItems entries or maxBytes of audio of cold in
+// memory, a limit of 0 disables it. blobDir is used to read the audio of
+// entries stored on disk.
+func NewTiered(cold Store, maxBytes int64, maxItems int, blobDir string) Store {
+	return &tieredStore{
+		hot:     NewLRU(newMemoryStore(0, 10*time.Minute), maxBytes, maxItems),
+		cold:    cold,
+		blobDir: blobDir,
+	}
+}
+
+func (s *tieredStore) Get(key string) (Entry, bool) {
+	if entry, ok := s.hot.Get(key); ok {
+		return entry, true
+	}
+	entry, ok := s.cold.Get(key)
+	if !ok {
+		return Entry{}, false
+	}
+	s.promote(key, entry)
+	return entry, true
+}
+
+func (s *tieredStore) Set(key string, entry Entry, ttl time.Duration) {
+	s.cold.Set(key, entry, ttl)
+	s.promote(key, entry)
+}
+
+func (s *tieredStore) Delete(key string) {
+	s.hot.Delete(key)
+	s.cold.Delete(key)
+}
+
+func (s *tieredStore) Items() map[string]Entry {
+	return s.cold.Items()
+}
+
+func (s *tieredStore) Stats() Stats {
+	return s.cold.Stats()
+}
+
+// promote keeps the entry with its audio in memory until it expires.
+func (s *tieredStore) promote(key string, entry Entry) {
+	var ttl time.Duration
+	if !entry.Expires.IsZero() {
+		ttl = time.Until(entry.Expires)
+		if ttl <= 0 {
+			return
+		}
+	}
+	if entry.Blob != "" {
+		audio, err := readAudio(entry, s.blobDir)
+		if err != nil {
+			slog.Error("Failed to read audio blob", "error", err)
+			return
+		}
+		entry.Audio, entry.Blob = audio, ""
+	}
+	s.hot.Set(key, entry, ttl)
+}
+
+func readAudio(entry Entry, blobDir string) ([]byte, error) {
+	audio, err := Open(entry, blobDir)
+	if err != nil {
+		return nil, err
+	}
+	defer audio.Close()
+	return io.ReadAll(audio)
+}
@@ -0,0 +1,59 @@
+// Package server runs the speech cache inside another Go program.
+//
+//	cfg := server.DefaultConfig()
+//	cfg.AzureKey = "..."
+//	cfg.AzureRegion = "westeurope"
+//	srv, err := server.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	go srv.Run(ctx)
+//	defer srv.Close()
+//	http.Handle("/speech/", http.StripPrefix("/speech", srv))
+package server
+
+import (
+	"github.com/nerijusdu/azure-speech-cache/internal/api"
+)
+
+// Config has the settings of the server, the fields match the environment
+// variables in the README.
+type Config = api.Config
+
+// Server is the http api of the speech cache, it also has the grpc api
+// and the background tasks.
+type Server = api.Server
+
+// Request is the body of a /tts request, it's used to warm the cache.
+type Request = api.TTSRequest
+
+// WarmResult is returned by Server.Warm.
+type WarmResult = api.WarmResult
+
+// ImportResult is returned by Server.ImportDir.
+type ImportResult = api.ImportResult
+
+// PurgeFilter selects the entries deleted by Server.Purge.
+type PurgeFilter = api.PurgeFilter
+
+// DefaultConfig returns the settings used when nothing is configured.
+func DefaultConfig() Config {
+	return api.DefaultConfig()
+}
+
+// ConfigFromEnv reads the settings from the environment variables.
+func ConfigFromEnv() (Config, error) {
+	return api.ConfigFromEnv()
+}
+
+// LoadConfig reads the settings from a yaml config file, the environment
+// variables override them.
+func LoadConfig(path string) (Config, error) {
+	return api.LoadConfig(path)
+}
+
+// New applies the config and loads the cache. The settings are package
+// level, so only one server can be created per process.
+func New(cfg Config) (*Server, error) {
+	return api.New(cfg)
+}